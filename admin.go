@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefix of admin API paths
+const adminAPIPrefix = "/api/v1/"
+
+// chat configuration of admin API
+type tAdminChat struct {
+	ChatID         int64       `json:"chat_id"`
+	Title          string      `json:"title"`
+	Enabled        bool        `json:"enabled"`
+	Timeout        int         `json:"timeout"`
+	ActiveWindows  []string    `json:"active_windows"`
+	TimeZone       string      `json:"time_zone"`
+	ArchiveChatID  int64       `json:"archive_chat_id"`
+	ArchiveForward bool        `json:"archive_forward"`
+	ResetOnEdit    bool        `json:"reset_on_edit"`
+	Pending        int         `json:"pending"`
+	Stats          *tChatStats `json:"stats,omitempty"`
+	Job            string      `json:"job,omitempty"`
+}
+
+// chat configuration change of admin API, only set fields are changed
+type tAdminChatUpdate struct {
+	Timeout *int  `json:"timeout"`
+	Enabled *bool `json:"enabled"`
+}
+
+// purge request of admin API
+type tAdminPurge struct {
+	Last        int    `json:"last"`
+	Older       string `json:"older"`
+	ContentType string `json:"type"`
+	SenderID    int    `json:"sender_id"`
+}
+
+// bot status of admin API
+type tAdminStatus struct {
+	Version string        `json:"version"`
+	Account string        `json:"account"`
+	Uptime  string        `json:"uptime"`
+	Chats   int           `json:"chats"`
+	Health  tHealthReport `json:"health"`
+}
+
+// create admin API chat from configuration, stats are loaded for details only
+func newAdminChat(config *tChatConfig, details bool) tAdminChat {
+	chat := tAdminChat{
+		ChatID:         config.ChatID,
+		Title:          config.ChatTitle,
+		Enabled:        config.Enabled,
+		Timeout:        config.Timeout,
+		ActiveWindows:  make([]string, 0, len(config.ActiveWindows)),
+		TimeZone:       config.TimeZone,
+		ArchiveChatID:  config.ArchiveChatID,
+		ArchiveForward: config.ArchiveForward,
+		ResetOnEdit:    config.ResetOnEdit,
+	}
+	for _, window := range config.ActiveWindows {
+		chat.ActiveWindows = append(chat.ActiveWindows, window.String())
+	}
+
+	if !details {
+		chat.Pending = len(config.GetAllChatMessage())
+		return chat
+	}
+
+	stats := config.Stats()
+	chat.Stats = &stats
+	chat.Pending = stats.Pending
+	if job := JOBS.Get(config.ChatID); job != nil {
+		chat.Job = job.String()
+	}
+	return chat
+}
+
+// write value as JSON response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// write error as JSON response
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// admin API handler, requests are authenticated by bearer token
+func adminAPIHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+
+		path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, adminAPIPrefix), "/"), "/")
+		apiLog := log.WithFields(log.Fields{"method": r.Method, "path": r.URL.Path})
+		apiLog.Debug("Admin API request")
+
+		switch {
+		case len(path) == 1 && path[0] == "status":
+			adminStatus(w, r)
+		case len(path) == 1 && path[0] == "chats":
+			adminChats(w, r)
+		case len(path) >= 2 && path[0] == "chats":
+			chatID, err := strconv.ParseInt(path[1], 10, 64)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, errors.New("invalid chat ID"))
+				return
+			}
+			if !CONFIGS.Exist(chatID) {
+				writeJSONError(w, http.StatusNotFound, fmt.Errorf("chat %d is not configured", chatID))
+				return
+			}
+
+			config := CONFIGS.Get(chatID)
+			switch {
+			case len(path) == 2:
+				adminChat(w, r, config)
+			case len(path) == 3 && path[2] == "purge":
+				adminPurge(w, r, config)
+			default:
+				writeJSONError(w, http.StatusNotFound, errors.New("not found"))
+			}
+		default:
+			writeJSONError(w, http.StatusNotFound, errors.New("not found"))
+		}
+	})
+}
+
+// GET /api/v1/status
+func adminStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tAdminStatus{
+		Version: VERSION,
+		Account: BOT.Self.UserName,
+		Uptime:  time.Since(time.Unix(0, healthStarted)).Round(time.Second).String(),
+		Chats:   CONFIGS.Len(),
+		Health:  healthReport(true),
+	})
+}
+
+// GET /api/v1/chats
+func adminChats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	chats := make([]tAdminChat, 0)
+	for _, config := range CONFIGS.List() {
+		chats = append(chats, newAdminChat(config, false))
+	}
+	writeJSON(w, http.StatusOK, chats)
+}
+
+// GET, PATCH and DELETE /api/v1/chats/<chat ID>
+func adminChat(w http.ResponseWriter, r *http.Request, config *tChatConfig) {
+	apiLog := chatLog(config.ChatID).WithField("method", r.Method)
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, newAdminChat(config, true))
+	case http.MethodPatch:
+		var update tAdminChatUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+			return
+		}
+
+		// invalid timeout is rejected before anything is changed
+		if update.Timeout != nil {
+			if err := checkTimeout(*update.Timeout); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		if err := config.ChangeTimeoutAndStatus(update.Timeout, update.Enabled); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		changed := log.Fields{}
+		if update.Timeout != nil {
+			changed["timeout"] = *update.Timeout
+		}
+		if update.Enabled != nil {
+			changed["enabled"] = *update.Enabled
+		}
+		apiLog.WithFields(changed).Info("Configuration changed by admin API")
+		// changes replace the config, the chat may be forgotten meanwhile
+		updated := CONFIGS.Get(config.ChatID)
+		if updated == nil {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("chat %d is not configured", config.ChatID))
+			return
+		}
+		writeJSON(w, http.StatusOK, newAdminChat(updated, true))
+	case http.MethodDelete:
+		forgotten := forgetChat(config)
+		apiLog.WithField("forgotten", forgotten).Info("Chat forgotten by admin API")
+		writeJSON(w, http.StatusOK, map[string]int{"forgotten": forgotten})
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// POST /api/v1/chats/<chat ID>/purge
+func adminPurge(w http.ResponseWriter, r *http.Request, config *tChatConfig) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	var purge tAdminPurge
+	if err := json.NewDecoder(r.Body).Decode(&purge); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+		return
+	}
+
+	filter := tPurgeFilter{Last: purge.Last, SenderID: purge.SenderID, ContentType: purge.ContentType}
+	if len(purge.Older) > 0 {
+		duration, err := time.ParseDuration(purge.Older)
+		if err != nil || duration <= 0 {
+			writeJSONError(w, http.StatusBadRequest, errors.New("invalid duration"))
+			return
+		}
+		filter.OlderThan = int(duration.Seconds())
+	}
+	if filter.Last < 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("invalid number of last messages"))
+		return
+	}
+	if filter == (tPurgeFilter{}) {
+		writeJSONError(w, http.StatusBadRequest, errors.New("no purge filter"))
+		return
+	}
+
+	messages := filter.Select(config.GetAllChatMessage(), int(time.Now().Unix()))
+	job, err := JOBS.Start(config, "purge", messages, nil, nil)
+	if err != nil {
+		writeJSONError(w, http.StatusConflict, err)
+		return
+	}
+
+	chatLog(config.ChatID).WithFields(log.Fields{"filter": filter.String(), "selected": len(messages)}).Info(
+		"Purge started by admin API")
+	writeJSON(w, http.StatusAccepted, map[string]int{"job_id": job.ID, "selected": len(messages)})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sync"
+)
+
+// deleted message audit record
+type tAuditRecord struct {
+	ChatID      int64  `json:"chat_id"`
+	MsgID       int    `json:"message_id"`
+	SenderID    int    `json:"sender_id,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	TimeStamp   int    `json:"timestamp"`
+	DeletedAt   int64  `json:"deleted_at"`
+	Result      string `json:"result"`
+	Error       string `json:"error,omitempty"`
+}
+
+// audit log results
+const (
+	auditDeleted       = "deleted"
+	auditNotFound      = "not_found"
+	auditNotDeletable  = "not_deletable"
+	auditFailed        = "failed"
+	auditArchiveFailed = "archive_failed"
+)
+
+// JSON Lines audit log with size based rotation
+type tAuditLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+// open audit log file for appending
+func NewAuditLog(path string, maxSize int64, backups int) (*tAuditLog, error) {
+	auditLog := &tAuditLog{path: path, maxSize: maxSize, backups: backups}
+	if err := auditLog.open(); err != nil {
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+func (a *tAuditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// method appending record to audit log
+func (a *tAuditLog) Write(record tAuditRecord) {
+	line, _ := json.Marshal(record)
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.maxSize > 0 && a.size+int64(len(line)) > a.maxSize && a.size > 0 {
+		if err := a.rotate(); err != nil {
+			log.WithError(err).Error("Failed to rotate audit log")
+		}
+	}
+
+	// reopen file after failed rotation
+	if a.file == nil {
+		if err := a.open(); err != nil {
+			log.WithError(err).Error("Failed to open audit log")
+			return
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		log.WithError(err).Error("Failed to write audit log")
+	}
+}
+
+// method rotating audit log files: path -> path.1 -> ... -> path.<backups>
+func (a *tAuditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		log.WithError(err).Error("Failed to close audit log")
+	}
+	a.file = nil
+
+	if a.backups > 0 {
+		for i := a.backups - 1; i > 0; i-- {
+			from := fmt.Sprintf("%s.%d", a.path, i)
+			if _, err := os.Stat(from); err == nil {
+				os.Rename(from, fmt.Sprintf("%s.%d", a.path, i+1))
+			}
+		}
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(a.path); err != nil {
+		return err
+	}
+
+	return a.open()
+}
+
+// method closing audit log file
+func (a *tAuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
@@ -5,25 +5,43 @@ import (
 	"fmt"
 	"github.com/go-redis/redis"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
-	"log"
+	log "github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
 var (
-	DB      *redis.Client
+	DB      redis.UniversalClient
 	BOT     *tgbotapi.BotAPI
-	CONFIGS Configs
+	CONFIGS *Configs
 	VERSION string
-	SETTING *botSetting
+	AUDIT   *tAuditLog
+	// path to YAML or TOML configuration file
+	CONFIGPATH string
+	// state file paths and import mode of -export and -import
+	EXPORTPATH string
+	IMPORTPATH string
+	IMPORTMODE string
+	// run storage migrations and exit, dry run only reports them
+	MIGRATE bool
+	DRYRUN  bool
 )
 
-func init() {
+// parse command line flags, flags printing information exit
+func parseFlags() {
 	VERSION = "0.1"
 
 	version := flag.Bool("version", false, "Print version")
 	command := flag.Bool("manual", false, "Print bot manual")
+	flag.StringVar(&CONFIGPATH, "config", "", "Path to YAML or TOML configuration file")
+	check := flag.Bool("check-config", false, "Print effective configuration and exit, non-zero exit code on problems")
+	flag.StringVar(&EXPORTPATH, "export", "", "Export chat configurations and messages to JSON file and exit")
+	flag.StringVar(&IMPORTPATH, "import", "", "Import chat configurations and messages from JSON file and exit")
+	flag.StringVar(&IMPORTMODE, "import-mode", importMerge,
+		"Import mode: merge keeps existing records, replace deletes records missing in the file")
+	flag.BoolVar(&MIGRATE, "migrate", false, "Upgrade storage schema and exit")
+	flag.BoolVar(&DRYRUN, "dry-run", false, "With -migrate report records to upgrade without writing")
 	flag.Parse()
 
 	if *version {
@@ -32,62 +50,149 @@ func init() {
 	} else if *command {
 		fmt.Print(StartMsg, HelpMsg)
 		os.Exit(0)
+	} else if *check {
+		os.Exit(checkConfig(CONFIGPATH))
+	} else if DRYRUN && !MIGRATE {
+		fmt.Fprintln(os.Stderr, "Dry run is supported only with -migrate")
+		os.Exit(2)
+	} else if IMPORTMODE != importMerge && IMPORTMODE != importReplace {
+		fmt.Fprintln(os.Stderr, "Import mode must be merge or replace")
+		os.Exit(2)
 	}
 }
 
 func main() {
-	log.Printf("*** Garbage Collector Bot. Version: %s ***", VERSION)
-	// load SETTING
-	SETTING = parseSetting(loadSettingFromEnv())
-	log.Printf("Bot setting: %s", SETTING)
+	parseFlags()
+
+	// load setting, system env overrides configuration file
+	setting, configFile, errs := loadSetting(CONFIGPATH)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		log.Fatal("Invalid bot setting")
+	}
+	storeSetting(setting)
+	if err := setupLogger(setting.logFormat, setting.logLevel); err != nil {
+		log.WithError(err).Fatal("Invalid logger setting")
+	}
+	log.WithField("version", VERSION).Info("*** Garbage Collector Bot ***")
+	log.WithField("setting", setting.String()).Info("Bot setting loaded")
+
+	var err error
+	DB, err = newRedisClient(setting)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid Redis setting")
+	}
 
-	DB = redis.NewClient(&redis.Options{
-		DB:       SETTING.dbRedisDB,
-		Addr:     SETTING.dbRedisAddress,
-		Password: SETTING.dbRedisPassword,
-	})
+	instrumentRedis(DB)
 
 	// try ping redis
-	_, err := DB.Ping().Result()
-	if err != nil {
-		log.Fatal("Error occurred with connect to Redis:", err)
+	if err := DB.Ping().Err(); err != nil {
+		log.WithError(err).Fatal("Failed to connect to Redis")
 	}
 
-	CONFIGS = GetChatConfigs()
-	log.Println("Loading configurations:", len(CONFIGS))
+	// offline tools connect to telegram only to get the default key prefix
+	offline := MIGRATE || len(EXPORTPATH) > 0 || len(IMPORTPATH) > 0
+	if !offline || len(setting.keyPrefix) == 0 {
+		BOT = connectBot(setting)
+	}
+	KEYPREFIX = setting.keyPrefix
+	if len(KEYPREFIX) == 0 {
+		KEYPREFIX = BOT.Self.UserName
+	}
+	log.WithField("prefix", KEYPREFIX).Info("Redis key prefix")
 
-	// chan for BOT command handler
-	cmdChan := make(chan *tgbotapi.Message, 50)
-	// chan for signal handler
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	// storage is upgraded before any record is read
+	if err := migrateSchema(MIGRATE && DRYRUN); err != nil {
+		log.WithError(err).Fatal("Failed to migrate storage schema")
+	}
+	if MIGRATE {
+		os.Exit(0)
+	}
 
-	if SETTING.useSocksProxy {
-		socks := SETTING.socksParams
-		socksClient := socksProxyClient(socks.socksAddress, socks.socksUser, socks.socksPassword)
-		BOT, err = tgbotapi.NewBotAPIWithClient(SETTING.botToken, socksClient)
-	} else {
-		BOT, err = tgbotapi.NewBotAPI(SETTING.botToken)
+	// export and import work with Redis only, the bot is not started
+	if len(EXPORTPATH) > 0 {
+		if err := exportState(EXPORTPATH); err != nil {
+			log.WithError(err).Fatal("Failed to export state")
+		}
+		os.Exit(0)
+	} else if len(IMPORTPATH) > 0 {
+		if err := importState(IMPORTPATH, IMPORTMODE); err != nil {
+			log.WithError(err).Fatal("Failed to import state")
+		}
+		os.Exit(0)
 	}
 
-	if err != nil {
-		log.Fatal("Connection error to bot API telegram:", err)
+	if len(setting.auditLogPath) > 0 {
+		AUDIT, err = NewAuditLog(setting.auditLogPath, setting.auditLogMaxSize, setting.auditLogMaxBackups)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to open audit log")
+		}
+		log.WithField("path", setting.auditLogPath).Info("Audit log of deleted messages enabled")
 	}
 
-	log.Printf("Authorized on account %s", BOT.Self.UserName)
+	CONFIGS = GetChatConfigs()
+	if err := seedChatConfigs(configFile.Chats); err != nil {
+		log.WithError(err).Fatal("Invalid predefined chat configuration")
+	}
+	log.WithField("count", CONFIGS.Len()).Info("Chat configurations loaded")
+
+	// admin API reads chat configurations, the server is started after they are loaded
+	if len(setting.httpAddress) > 0 {
+		startHTTPServer(setting.httpAddress, setting.adminToken)
+	}
 
-	BOT.Debug = SETTING.botDebug
+	// chan for BOT command handler
+	cmdChan := make(chan *tBotMessage, 50)
+	// chan for signal handler
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	go botUpdateMsgHandler(cmdChan)
 	go botCommandHandler(cmdChan)
-	go garbageCollectorHandler(SETTING.gcTimeout)
+	go garbageCollectorHandler()
 
 	for true {
 		sig := <-signals
-		log.Println("Catch signal", sig)
-		BOT.StopReceivingUpdates()
+		log.WithField("signal", sig).Info("Catch signal")
+		if sig == syscall.SIGHUP {
+			reloadSetting(CONFIGPATH)
+			continue
+		}
+		close(stopUpdates)
 		close(cmdChan)
-		log.Println("Bot exit")
+		if AUDIT != nil {
+			AUDIT.Close()
+		}
+		log.Info("Bot exit")
 		os.Exit(0)
 	}
 }
+
+// connect to telegram bot API directly or through proxy
+func connectBot(setting *botSetting) *tgbotapi.BotAPI {
+	var bot *tgbotapi.BotAPI
+	var err error
+
+	if setting.useSocksProxy {
+		socks := setting.socksParams
+		socksClient := socksProxyClient(socks.socksAddress, socks.socksUser, socks.socksPassword)
+		bot, err = tgbotapi.NewBotAPIWithClient(setting.botToken, socksClient)
+	} else if setting.useHTTPSProxy {
+		https := setting.httpsParams
+		httpsClient := httpsProxyClient(https.httpsAddress, https.httpsUser, https.httpsPassword)
+		bot, err = tgbotapi.NewBotAPIWithClient(setting.botToken, httpsClient)
+	} else {
+		bot, err = tgbotapi.NewBotAPI(setting.botToken)
+	}
+
+	if err != nil {
+		log.WithError(err).Fatal("Failed to connect to Telegram bot API")
+	}
+
+	log.WithField("account", bot.Self.UserName).Info("Authorized on account")
+
+	bot.Debug = setting.botDebug
+	return bot
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// keys of the file setting section, environment variables without gc_ prefix
+const fileSettingKeys = " token bot_debug check_timeout redis_addr redis_db redis_pwd redis_user " +
+	"redis_master redis_sentinel_addr redis_cluster redis_tls redis_tls_ca redis_tls_cert redis_tls_key " +
+	"use_socks5 socks5_addr socks5_user socks5_pwd " +
+	"use_https_proxy https_proxy_addr https_proxy_user https_proxy_pwd " +
+	"timeout_limit catchup_rate backfill_limit " +
+	"http_addr health_updates_timeout health_gc_timeout log_level log_format " +
+	"audit_log audit_log_max_size audit_log_max_backups operator_id admin_token key_prefix "
+
+// default timeout of new chat configuration
+const defaultChatTimeout = 3600
+
+// configuration file type
+type tConfigFile struct {
+	Setting map[string]interface{} `yaml:"setting" toml:"setting"`
+	Chats   []tPredefinedChat      `yaml:"chats" toml:"chats"`
+}
+
+// predefined chat configuration type
+type tPredefinedChat struct {
+	ID             int64              `yaml:"id" toml:"id"`
+	Title          string             `yaml:"title" toml:"title"`
+	Timeout        string             `yaml:"timeout" toml:"timeout"`
+	Enabled        *bool              `yaml:"enabled" toml:"enabled"`
+	Windows        []string           `yaml:"windows" toml:"windows"`
+	TimeZone       string             `yaml:"timezone" toml:"timezone"`
+	ArchiveChatID  int64              `yaml:"archive" toml:"archive"`
+	ArchiveForward bool               `yaml:"archive_forward" toml:"archive_forward"`
+	ResetOnEdit    bool               `yaml:"reset_on_edit" toml:"reset_on_edit"`
+	Topics         []tPredefinedTopic `yaml:"topics" toml:"topics"`
+}
+
+// predefined forum topic configuration type
+type tPredefinedTopic struct {
+	ID      int    `yaml:"id" toml:"id"`
+	Timeout string `yaml:"timeout" toml:"timeout"`
+	Enabled *bool  `yaml:"enabled" toml:"enabled"`
+}
+
+// load YAML or TOML configuration file, the format is chosen by file extension
+func loadConfigFile(path string) (*tConfigFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file tConfigFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(data, &file); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), &file)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown key %s", undecoded[0])
+		}
+	default:
+		return nil, errors.New("configuration file must be .yaml, .yml or .toml")
+	}
+
+	for key := range file.Setting {
+		if !strings.Contains(fileSettingKeys, " "+strings.TrimSuffix(key, "_file")+" ") {
+			return nil, fmt.Errorf("unknown setting %s", key)
+		}
+	}
+
+	chatIDs := make(map[int64]bool)
+	for _, chat := range file.Chats {
+		if chat.ID == 0 {
+			return nil, errors.New("chat ID not set")
+		}
+		if chatIDs[chat.ID] {
+			return nil, fmt.Errorf("chat %d is defined twice", chat.ID)
+		}
+		chatIDs[chat.ID] = true
+	}
+	return &file, nil
+}
+
+// method getting setting in the same form as system env, env values override file values
+func (file tConfigFile) mergeSetting(env map[string]string) (map[string]string, error) {
+	settingMap := make(map[string]string)
+	for key, value := range file.Setting {
+		switch value.(type) {
+		case string, bool, int, int64, float64:
+			settingMap["gc_"+key] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("setting %s must be a string, number or boolean", key)
+		}
+	}
+
+	// env value or file of env replaces both forms of file setting
+	for key, value := range env {
+		name := strings.TrimSuffix(key, "_file")
+		delete(settingMap, name)
+		delete(settingMap, name+"_file")
+		settingMap[key] = value
+	}
+	return settingMap, nil
+}
+
+// load setting from configuration file and system env
+func loadSetting(path string) (*botSetting, *tConfigFile, []error) {
+	rawSetting := loadSettingFromEnv()
+	configFile := &tConfigFile{}
+
+	if len(path) > 0 {
+		var err error
+		if configFile, err = loadConfigFile(path); err != nil {
+			return nil, nil, []error{fmt.Errorf("configuration file %s: %s", path, err)}
+		}
+		if rawSetting, err = configFile.mergeSetting(rawSetting); err != nil {
+			return nil, nil, []error{fmt.Errorf("configuration file %s: %s", path, err)}
+		}
+	}
+
+	setting, errs := parseSetting(rawSetting)
+	return setting, configFile, errs
+}
+
+// settings applied on reload, others require restart
+const reloadableSettings = " gcTimeout timeoutLimit backfillLimit " +
+	"healthUpdatesTimeout healthGCTimeout logLevel logFormat operatorID "
+
+// reload setting from configuration file and system env,
+// the setting is not changed if settings requiring restart are changed
+func reloadSetting(path string) {
+	setting, _, errs := loadSetting(path)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		log.Error("Setting not reloaded, invalid bot setting")
+		return
+	}
+
+	changed := log.Fields{}
+	rejected := make([]string, 0)
+	oldFields, newFields := currentSetting().fields(false), setting.fields(false)
+	for i, field := range newFields {
+		if field[1] == oldFields[i][1] {
+			continue
+		}
+		if strings.Contains(reloadableSettings, " "+field[0]+" ") {
+			changed[field[0]] = field[1]
+		} else {
+			rejected = append(rejected, field[0])
+		}
+	}
+
+	if len(rejected) > 0 {
+		log.WithField("settings", strings.Join(rejected, ", ")).Error(
+			"Setting not reloaded, changed settings require restart")
+		return
+	}
+	if len(changed) == 0 {
+		log.Info("Setting reloaded, nothing changed")
+		return
+	}
+
+	if err := setupLogger(setting.logFormat, setting.logLevel); err != nil {
+		log.WithError(err).Error("Setting not reloaded, invalid logger setting")
+		return
+	}
+	storeSetting(setting)
+	log.WithFields(changed).Info("Setting reloaded")
+}
+
+// print effective redacted configuration and problems, returns exit code
+func checkConfig(path string) int {
+	setting, configFile, errs := loadSetting(path)
+	if setting != nil {
+		storeSetting(setting)
+		for _, field := range setting.fields(true) {
+			fmt.Printf("%s: %s\n", field[0], field[1])
+		}
+		for _, chat := range configFile.Chats {
+			if _, err := chat.config(); err != nil {
+				errs = append(errs, fmt.Errorf("chat %d: %s", chat.ID, err))
+			}
+		}
+		fmt.Printf("predefinedChats: %d\n", len(configFile.Chats))
+	}
+
+	if len(errs) > 0 {
+		fmt.Fprintln(os.Stderr, "Configuration problems:")
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, "  "+err.Error())
+		}
+		return 1
+	}
+	fmt.Println("Configuration is valid")
+	return 0
+}
+
+// parse timeout of predefined chat or topic, default timeout is used if not set
+func parsePredefinedTimeout(raw string) (int, error) {
+	if len(raw) == 0 {
+		return defaultChatTimeout, nil
+	}
+
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %s", raw)
+	}
+	timeout := int(duration.Seconds())
+	return timeout, checkTimeout(timeout)
+}
+
+// method creating chat configuration from predefined one
+func (chat tPredefinedChat) config() (*tChatConfig, error) {
+	timeout, err := parsePredefinedTimeout(chat.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tChatConfig{
+		ChatID:         chat.ID,
+		ChatTitle:      chat.Title,
+		Timeout:        timeout,
+		Enabled:        chat.Enabled == nil || *chat.Enabled,
+		TimeZone:       chat.TimeZone,
+		ArchiveChatID:  chat.ArchiveChatID,
+		ArchiveForward: chat.ArchiveForward,
+		ResetOnEdit:    chat.ResetOnEdit,
+	}
+
+	for _, raw := range chat.Windows {
+		window, err := parseActiveWindow(raw)
+		if err != nil {
+			return nil, err
+		}
+		config.ActiveWindows = append(config.ActiveWindows, window)
+	}
+
+	if len(chat.TimeZone) > 0 {
+		if _, err := time.LoadLocation(chat.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %s", chat.TimeZone)
+		}
+	}
+
+	if chat.ArchiveChatID == chat.ID {
+		return nil, errors.New("archive chat must differ from the chat")
+	}
+
+	for _, topic := range chat.Topics {
+		if topic.ID <= 0 {
+			return nil, errors.New("topic ID must be greater than 0")
+		}
+		override := config.topicOverride(topic.ID)
+		override.Enabled = topic.Enabled == nil || *topic.Enabled
+		if len(topic.Timeout) > 0 {
+			if override.Timeout, err = parsePredefinedTimeout(topic.Timeout); err != nil {
+				return nil, fmt.Errorf("topic %d: %s", topic.ID, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// save predefined chat configurations which are not in the store yet,
+// configurations changed by chat commands are kept
+func seedChatConfigs(chats []tPredefinedChat) error {
+	for _, chat := range chats {
+		config, err := chat.config()
+		if err != nil {
+			return fmt.Errorf("chat %d: %s", chat.ID, err)
+		}
+
+		if CONFIGS.Exist(chat.ID) {
+			chatLog(chat.ID).Debug("Predefined chat configuration already exists")
+			continue
+		}
+		if !config.Save() {
+			return fmt.Errorf("chat %d: configuration not saved", chat.ID)
+		}
+		CONFIGS.Set(config)
+		chatLog(chat.ID).Info("Predefined chat configuration seeded")
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		chats   int
+		invalid bool
+	}{
+		{
+			name: "yaml",
+			file: "bot.yaml",
+			data: "setting:\n  token: abc\n  redis_db: 2\n  redis_pwd_file: /run/secrets/redis\n" +
+				"chats:\n  - id: -100\n    timeout: 1h\n",
+			chats: 1,
+		},
+		{
+			name:  "toml",
+			file:  "bot.toml",
+			data:  "[setting]\ntoken = \"abc\"\nbot_debug = true\n\n[[chats]]\nid = -100\n\n[[chats]]\nid = -200\n",
+			chats: 2,
+		},
+		{name: "yaml unknown setting", file: "bot.yml", data: "setting:\n  colour: red\n", invalid: true},
+		{name: "yaml unknown section", file: "bot.yaml", data: "settings:\n  token: abc\n", invalid: true},
+		{name: "yaml unknown chat key", file: "bot.yaml", data: "chats:\n  - id: -100\n    title_text: x\n", invalid: true},
+		{name: "toml unknown setting", file: "bot.toml", data: "[setting]\ncolour = \"red\"\n", invalid: true},
+		{name: "toml unknown section", file: "bot.toml", data: "[settings]\ntoken = \"abc\"\n", invalid: true},
+		{name: "toml unknown chat key", file: "bot.toml", data: "[[chats]]\nid = -100\ntitle_text = \"x\"\n", invalid: true},
+		{name: "chat without ID", file: "bot.yaml", data: "chats:\n  - title: x\n", invalid: true},
+		{name: "chat defined twice", file: "bot.yaml", data: "chats:\n  - id: -100\n  - id: -100\n", invalid: true},
+		{name: "unknown extension", file: "bot.json", data: "{}", invalid: true},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.file)
+		if err := ioutil.WriteFile(path, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := loadConfigFile(path)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		if len(file.Chats) != test.chats {
+			t.Errorf("%s: got %d chats, expected %d", test.name, len(file.Chats), test.chats)
+		}
+	}
+}
+
+func TestMergeSetting(t *testing.T) {
+	tests := []struct {
+		name     string
+		setting  map[string]interface{}
+		env      map[string]string
+		expected map[string]string
+		invalid  bool
+	}{
+		{
+			name:     "scalar values",
+			setting:  map[string]interface{}{"token": "abc", "redis_db": 2, "bot_debug": true, "catchup_rate": 2.5},
+			expected: map[string]string{"gc_token": "abc", "gc_redis_db": "2", "gc_bot_debug": "true", "gc_catchup_rate": "2.5"},
+		},
+		{
+			name:     "env overrides value",
+			setting:  map[string]interface{}{"token": "file"},
+			env:      map[string]string{"gc_token": "env"},
+			expected: map[string]string{"gc_token": "env"},
+		},
+		{
+			name:     "env overrides file of value",
+			setting:  map[string]interface{}{"token_file": "/run/secrets/token"},
+			env:      map[string]string{"gc_token": "env"},
+			expected: map[string]string{"gc_token": "env"},
+		},
+		{
+			name:     "env file overrides value",
+			setting:  map[string]interface{}{"token": "file"},
+			env:      map[string]string{"gc_token_file": "/run/secrets/token"},
+			expected: map[string]string{"gc_token_file": "/run/secrets/token"},
+		},
+		{
+			name:     "env file overrides file of value",
+			setting:  map[string]interface{}{"token_file": "/etc/token"},
+			env:      map[string]string{"gc_token_file": "/run/secrets/token"},
+			expected: map[string]string{"gc_token_file": "/run/secrets/token"},
+		},
+		{
+			name:     "other file values kept",
+			setting:  map[string]interface{}{"token": "file", "redis_addr": "redis:6379"},
+			env:      map[string]string{"gc_token": "env", "home": "/root"},
+			expected: map[string]string{"gc_token": "env", "gc_redis_addr": "redis:6379", "home": "/root"},
+		},
+		{
+			name:    "list value",
+			setting: map[string]interface{}{"redis_sentinel_addr": []interface{}{"a:26379", "b:26379"}},
+			invalid: true,
+		},
+		{
+			name:    "map value",
+			setting: map[string]interface{}{"redis_addr": map[interface{}]interface{}{"host": "redis"}},
+			invalid: true,
+		},
+		{
+			name:    "empty value",
+			setting: map[string]interface{}{"token": nil},
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		settingMap, err := tConfigFile{Setting: test.setting}.mergeSetting(test.env)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", test.name, settingMap)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		if len(settingMap) != len(test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, settingMap, test.expected)
+			continue
+		}
+		for key, value := range test.expected {
+			if settingMap[key] != value {
+				t.Errorf("%s: %s is %q, expected %q", test.name, key, settingMap[key], value)
+			}
+		}
+	}
+}
@@ -1,24 +1,125 @@
 package main
 
 import (
-	"log"
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
 )
 
 // save key value to Redis
 func SaveToDB(key string, value []byte) error {
 	err := DB.Set(key, value, 0).Err()
 	if err != nil {
-		log.Println("Error occurred with save to Redis:", err)
+		log.WithFields(log.Fields{"key": key, "error": err}).Error("Failed to save to Redis")
 		return err
 	}
 	return nil
 }
 
+// update value of existing key in Redis, false if the key does not exist
+func UpdateInDB(key string, value []byte) (bool, error) {
+	updated, err := DB.SetXX(key, value, 0).Result()
+	if err != nil {
+		log.WithFields(log.Fields{"key": key, "error": err}).Error("Failed to update in Redis")
+		return false, err
+	}
+	return updated, nil
+}
+
+// load value by key from Redis, empty string if the key does not exist
+func LoadValueFromDB(key string) (string, error) {
+	value, err := DB.Get(key).Result()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		log.WithFields(log.Fields{"key": key, "error": err}).Error("Failed to get value from Redis")
+		return "", err
+	}
+	return value, nil
+}
+
+// increment hash field value in Redis
+func IncrementInDB(key, field string, value int64) error {
+	err := DB.HIncrBy(key, field, value).Err()
+	if err != nil {
+		log.WithFields(log.Fields{"key": key, "field": field, "error": err}).Error(
+			"Failed to increment hash field in Redis")
+		return err
+	}
+	return nil
+}
+
+// load all hash fields from Redis
+func LoadHashFromDB(key string) (map[string]string, error) {
+	values, err := DB.HGetAll(key).Result()
+	if err != nil {
+		log.WithFields(log.Fields{"key": key, "error": err}).Error("Failed to get hash from Redis")
+		return nil, err
+	}
+	return values, nil
+}
+
+// load type of key from Redis, "none" if the key does not exist
+func LoadTypeFromDB(key string) (string, error) {
+	keyType, err := DB.Type(key).Result()
+	if err != nil {
+		log.WithFields(log.Fields{"key": key, "error": err}).Error("Failed to get key type from Redis")
+		return "", err
+	}
+	return keyType, nil
+}
+
+// load keys matching filter from Redis, in cluster mode every master is scanned
+func LoadKeysFromDB(filter string) ([]string, error) {
+	var mutex sync.Mutex
+	found := make(map[string]bool)
+
+	// SCAN does not block Redis unlike KEYS, but may return a key more than once
+	scan := func(client redis.Cmdable) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(cursor, filter, 1000).Result()
+			if err != nil {
+				return err
+			}
+
+			mutex.Lock()
+			for _, key := range keys {
+				found[key] = true
+			}
+			mutex.Unlock()
+
+			if cursor = next; cursor == 0 {
+				return nil
+			}
+		}
+	}
+
+	var err error
+	if cluster, ok := DB.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(func(master *redis.Client) error {
+			return scan(master)
+		})
+	} else {
+		err = scan(DB)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"filter": filter, "error": err}).Error("Failed to load keys from Redis")
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
 // load data from Redis by filtered key
 func LoadFromDB(filter string) ([]string, error) {
-	keys, err := DB.Keys(filter).Result()
+	keys, err := LoadKeysFromDB(filter)
 	if err != nil {
-		log.Println("Error occurred with loading data from Redis:", err)
 		return nil, err
 	}
 
@@ -27,8 +128,8 @@ func LoadFromDB(filter string) ([]string, error) {
 	for i, key := range keys {
 		value, err := DB.Get(key).Result()
 		if err != nil {
-			log.Printf(
-				"Error occurred with getting value by key %s: %s. Skip...", key, err)
+			log.WithFields(log.Fields{"key": key, "error": err}).Warn(
+				"Failed to get value from Redis, skip")
 			continue
 		}
 		values[i] = value
@@ -36,11 +137,47 @@ func LoadFromDB(filter string) ([]string, error) {
 	return values, nil
 }
 
+// delete all keys matching filter from Redis, returns number of deleted keys
+func DeleteByFilterFromDB(filter string) (int, error) {
+	keys, err := LoadKeysFromDB(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, key := range keys {
+		if err := DeleteFromDB(key); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// move key with value of any type, false if the target key already exists
+func MoveInDB(from, to string) (bool, error) {
+	dump, err := DB.Dump(from).Result()
+	if err == redis.Nil {
+		return true, nil
+	} else if err != nil {
+		log.WithFields(log.Fields{"key": from, "error": err}).Error("Failed to dump key in Redis")
+		return false, err
+	}
+
+	// dump and restore work with single keys in every Redis mode unlike RENAME
+	if err := DB.Restore(to, 0, dump).Err(); err != nil {
+		if strings.HasPrefix(err.Error(), "BUSYKEY") {
+			return false, nil
+		}
+		log.WithFields(log.Fields{"key": to, "error": err}).Error("Failed to restore key in Redis")
+		return false, err
+	}
+	return true, DeleteFromDB(from)
+}
+
 // delete value by key from Redis
 func DeleteFromDB(key string) error {
 	err := DB.Del(key).Err()
 	if err != nil {
-		log.Printf("Error occurred with deleting value by key %s: %s", key, err)
+		log.WithFields(log.Fields{"key": key, "error": err}).Error("Failed to delete from Redis")
 		return err
 	}
 	return nil
@@ -1,208 +1,853 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
-	"log"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // new message handler
-func botUpdateMsgHandler(cmdChan chan *tgbotapi.Message) {
-	log.Println("Start new message handler")
+func botUpdateMsgHandler(cmdChan chan *tBotMessage) {
+	log.Info("Start new message handler")
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	updates, _ := BOT.GetUpdatesChan(u)
+	// next batch is requested only after the previous one is saved,
+	// so unsaved updates are received again after restart
+	offset := GetUpdateOffset()
+	log.WithField("offset", offset).Info("Receiving updates")
 
-	for update := range updates {
-		msg := update.Message
-		// skip non message updates
-		if msg == nil {
-			continue
+	for {
+		select {
+		case <-stopUpdates:
+			return
+		default:
 		}
 
-		// process message only for group or super group
-		if msg.Chat.IsGroup() || msg.Chat.IsSuperGroup() {
+		updates, err := getUpdates(offset, 60)
+		if err != nil {
+			log.WithError(err).Warn("Failed to get updates, retrying in 3 seconds")
+			time.Sleep(time.Second * 3)
+			continue
+		}
 
-			// if chat exist in config and enabled save new message
-			if CONFIGS.ExistAndEnable(msg.Chat.ID) {
-				NewMessage(msg.Chat.ID, msg.MessageID, msg.Date)
-				log.Printf("New message %d handled for chat %d", msg.MessageID, msg.Chat.ID)
-			} else {
-				log.Printf(
-					"Message %d not saved. Saving is disabled or there is no configuration for chat %d",
-					msg.MessageID, msg.Chat.ID)
+		markUpdatesHealth()
+		metricUpdates.Add(float64(len(updates)))
+		for _, update := range updates {
+			if update.UpdateID < offset {
+				continue
+			}
+			if !handleUpdate(update, cmdChan) || !SaveUpdateOffset(update.UpdateID) {
+				log.WithField("update_id", update.UpdateID).Warn(
+					"Failed to process update, retrying in 3 seconds")
+				time.Sleep(time.Second * 3)
+				break
 			}
+			offset = update.UpdateID + 1
+		}
+	}
+}
+
+// commands available for channels
+const channelCommands = " on off timeout delete purge cancel setting stats window timezone archive resetonedit backfill stop "
+
+// commands handled in private chat with the bot
+const privateCommands = " start help ping channel "
+
+// process single update, false if the update must be received again
+func handleUpdate(update tBotUpdate, cmdChan chan *tBotMessage) bool {
+	switch {
+	case update.EditedMessage != nil:
+		handleEditedMessage(update.EditedMessage)
+		return true
+	case update.EditedChannelPost != nil:
+		handleEditedMessage(update.EditedChannelPost)
+		return true
+	case update.ChannelPost != nil:
+		return handleChannelPost(update.ChannelPost)
+	}
 
-			// process command message
-			if msg.IsCommand() {
-				cmdChan <- msg
+	msg := update.Message
+	// skip non message updates
+	if msg == nil {
+		return true
+	}
+
+	// process message only for group or super group
+	if msg.Chat.IsGroup() || msg.Chat.IsSuperGroup() {
+
+		// if chat and topic exist in config and enabled save new message
+		if CONFIGS.ExistAndEnableTopic(msg.Chat.ID, msg.ThreadID) {
+			if !NewMessage(msg) {
+				return false
 			}
-			continue
+			chatLog(msg.Chat.ID).WithField("msg_id", msg.MessageID).Debug("New message handled")
+		} else {
+			chatLog(msg.Chat.ID).WithField("msg_id", msg.MessageID).Debug(
+				"Message not saved, saving is disabled or there is no chat configuration")
 		}
 
-		// process help and start command to bot
+		// process command message
 		if msg.IsCommand() {
-			cmd := strings.ToLower(msg.Command())
+			cmdChan <- msg
+		}
+		return true
+	}
+
+	// process help and start command to bot
+	if msg.IsCommand() {
+		cmd := strings.ToLower(msg.Command())
+
+		if strings.Contains(privateCommands, " "+cmd+" ") ||
+			strings.Contains(operatorCommands, " "+cmd+" ") && isOperator(msg) {
+			log.WithField("command", cmd).Debug("Private command handling")
+			cmdChan <- msg
+		}
+	}
+	return true
+}
 
-			if strings.Contains("start help ping", cmd) {
-				log.Printf("Bot %s command hanling", cmd)
-				cmdChan <- msg
+// process new channel post, false if the post is not saved
+func handleChannelPost(post *tBotMessage) bool {
+	if CONFIGS.ExistAndEnable(post.Chat.ID) {
+		if !NewMessage(post) {
+			return false
+		}
+		chatLog(post.Chat.ID).WithField("msg_id", post.MessageID).Debug("New post handled")
+	} else {
+		chatLog(post.Chat.ID).WithField("msg_id", post.MessageID).Debug(
+			"Post not saved, saving is disabled or there is no channel configuration")
+	}
+	return true
+}
+
+// process edited message, only already saved messages are updated
+func handleEditedMessage(msg *tBotMessage) {
+	if !CONFIGS.Exist(msg.Chat.ID) {
+		return
+	}
+
+	message, ok := GetMessage(msg.Chat.ID, msg.MessageID)
+	if !ok {
+		chatLog(msg.Chat.ID).WithField("msg_id", msg.MessageID).Debug(
+			"Edited message not saved, it is not tracked")
+		return
+	}
+
+	if message.SaveEdit(msg.EditDate) {
+		message.logger().Debug("Message edit handled")
+	}
+}
+
+// parse "/channel <channel ID|@username> <command> [arguments]" sent by channel admin
+func parseChannelCommand(msg *tBotMessage) (*tgbotapi.Chat, string, string, error) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) < 2 {
+		return nil, "", "", errors.New("expected channel and command")
+	}
+
+	channelConfig := tgbotapi.ChatConfig{SuperGroupUsername: args[0]}
+	if channelID, err := strconv.ParseInt(args[0], 10, 64); err == nil {
+		channelConfig = tgbotapi.ChatConfig{ChatID: channelID}
+	}
+	channel, err := BOT.GetChat(channelConfig)
+	if err != nil || !channel.IsChannel() {
+		return nil, "", "", errors.New("channel not found, the bot must be a channel admin")
+	}
+
+	if msg.From == nil || !isChatAdmin(channel.ID, msg.From.ID) {
+		return nil, "", "", errors.New("only channel admins can configure the channel")
+	}
+
+	command := strings.ToLower(strings.TrimPrefix(args[1], "/"))
+	if !strings.Contains(channelCommands, " "+command+" ") {
+		return nil, "", "", fmt.Errorf("command %s is not supported for channels", command)
+	}
+	return &channel, command, strings.Join(args[2:], " "), nil
+}
+
+// parse "/backfill <from ID> <to ID>" or "/backfill <duration>" arguments,
+// maxID is the newest existing message ID, 0 if unknown,
+// returns message ID range and the earliest timestamp of registered messages, 0 for ID range
+func parseBackfill(args string, lastID, maxID, now int) (int, int, int, error) {
+	fields := strings.Fields(args)
+	limit := currentSetting().backfillLimit
+
+	switch len(fields) {
+	case 1:
+		duration, err := time.ParseDuration(fields[0])
+		if err != nil || duration <= 0 {
+			return 0, 0, 0, errors.New("invalid duration")
+		}
+		if lastID <= 0 {
+			return 0, 0, 0, errors.New("no known messages to start from")
+		}
+
+		fromID := lastID - limit + 1
+		if fromID < 1 {
+			fromID = 1
+		}
+		return fromID, lastID, now - int(duration.Seconds()), nil
+	case 2:
+		fromID, fromErr := strconv.Atoi(fields[0])
+		toID, toErr := strconv.Atoi(fields[1])
+		if fromErr != nil || toErr != nil || fromID <= 0 || fromID > toID {
+			return 0, 0, 0, errors.New("invalid message ID range")
+		}
+		// future messages with registered IDs would be deleted by estimated time
+		if maxID > 0 && toID > maxID {
+			return 0, 0, 0, fmt.Errorf("message IDs must be lower than the command message ID %d", maxID+1)
+		}
+		if toID-fromID+1 > limit {
+			return 0, 0, 0, fmt.Errorf("range is limited to %d messages", limit)
+		}
+		return fromID, toID, 0, nil
+	}
+	return 0, 0, 0, errors.New("expected message ID range or duration")
+}
+
+// parse "/purge [last <N>] [older <duration>] [type <content type>]" arguments
+func parsePurgeFilter(args string) (tPurgeFilter, error) {
+	var filter tPurgeFilter
+
+	fields := strings.Fields(strings.ToLower(args))
+	if len(fields)%2 != 0 {
+		return filter, errors.New("expected filter name and value pairs")
+	}
+
+	for i := 0; i < len(fields); i += 2 {
+		name, value := fields[i], fields[i+1]
+		switch name {
+		case "last":
+			last, err := strconv.Atoi(value)
+			if err != nil || last <= 0 {
+				return filter, errors.New("invalid number of last messages")
+			}
+			filter.Last = last
+		case "older":
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return filter, errors.New("invalid duration")
 			}
+			filter.OlderThan = int(duration.Seconds())
+		case "type":
+			filter.ContentType = value
+		default:
+			return filter, fmt.Errorf("unknown filter %s", name)
 		}
 	}
+	return filter, nil
 }
 
-// send and save reply message
-func replyTo(chatID int64, msgID int, msgText string) *tgbotapi.Message {
-	newMsg := tgbotapi.NewMessage(chatID, msgText)
-	newMsg.ReplyToMessageID = msgID
-	replyMsg, err := BOT.Send(newMsg)
+// send and save reply message in the same topic
+func replyTo(msg *tBotMessage, msgText string) *tBotMessage {
+	replyMsg, err := sendMessage(msg.Chat.ID, msg.ThreadID, msg.MessageID, msgText)
 	if err != nil {
-		log.Println("Error occurred with sending reply:", err)
+		chatLog(msg.Chat.ID).WithError(err).Error("Failed to send reply")
 		return nil
 	}
 
-	log.Printf("Reply to %d sent", msgID)
+	chatLog(msg.Chat.ID).WithField("reply_to", msg.MessageID).Debug("Reply sent")
 
 	// save reply message
-	if CONFIGS.ExistAndEnable(chatID) {
-		NewMessage(chatID, replyMsg.MessageID, replyMsg.Date)
-		log.Println("Reply message saved to Redis")
+	if CONFIGS.ExistAndEnableTopic(replyMsg.Chat.ID, replyMsg.ThreadID) {
+		NewMessage(replyMsg)
+		chatLog(replyMsg.Chat.ID).WithField("msg_id", replyMsg.MessageID).Debug("Reply message saved")
 	}
 
-	return &replyMsg
+	return replyMsg
 }
 
 // bot command handler
-func botCommandHandler(cmdChan chan *tgbotapi.Message) {
-	log.Println("Start command handler")
+func botCommandHandler(cmdChan chan *tBotMessage) {
+	log.Info("Start command handler")
 
 	for msg := range cmdChan {
 		command := strings.ToLower(msg.Command())
-		log.Printf("Receive <%s> command from chat %d", command, msg.Chat.ID)
+		chat, threadID, args := msg.Chat, msg.ThreadID, msg.CommandArguments()
+		cmdLog := chatLog(chat.ID).WithField("command", command)
+		cmdLog.Info("Command received")
+		countCommand(command)
+
+		// operator console is available only in private chat with the operator
+		if strings.Contains(operatorCommands, " "+command+" ") && isOperator(msg) {
+			operatorCommand(msg, command, args)
+			continue
+		}
+
+		// channel is configured by its admin from private chat
+		if command == "channel" && msg.Chat.IsPrivate() {
+			var err error
+			chat, command, args, err = parseChannelCommand(msg)
+			if err != nil {
+				cmdLog.WithError(err).Warn("Channel command rejected")
+				replyTo(msg, fmt.Sprintf("Error! %s. Send a /help command to get help", err))
+				continue
+			}
+			threadID = 0
+			cmdLog = chatLog(chat.ID).WithField("command", command)
+			cmdLog.Info("Channel command received")
+		}
+		// command message is saved only in the chat it was sent to
+		inChat := chat.ID == msg.Chat.ID
 
 		switch command {
 		case "help":
-			replyTo(msg.Chat.ID, msg.MessageID, HelpMsg)
+			replyTo(msg, HelpMsg)
 		case "start":
-			replyTo(msg.Chat.ID, msg.MessageID, StartMsg)
+			replyTo(msg, StartMsg)
 		case "on":
-			if CONFIGS.Exist(msg.Chat.ID) {
+			if CONFIGS.Exist(chat.ID) && threadID != 0 {
+				// enable saving for topic
+				if !CONFIGS.Get(chat.ID).IsTopicEnabled(threadID) &&
+					CONFIGS.Get(chat.ID).ChangeTopicStatus(threadID, true) {
+
+					// save /on command message
+					if inChat {
+						NewMessage(msg)
+					}
+
+					replyTo(msg, "Enabled saving topic messages")
+					cmdLog.WithField("thread_id", threadID).Info("Saving enabled for topic")
+				} else {
+					replyTo(msg, "Saving topic message already enabled")
+				}
+			} else if CONFIGS.Exist(chat.ID) {
 				// if saving is disabled
-				if !CONFIGS[msg.Chat.ID].Enabled && CONFIGS[msg.Chat.ID].ChangeStatus(true) {
+				if !CONFIGS.Get(chat.ID).Enabled && CONFIGS.Get(chat.ID).ChangeStatus(true) {
 
 					// save /on command message
-					NewMessage(msg.Chat.ID, msg.MessageID, msg.Date)
+					if inChat {
+						NewMessage(msg)
+					}
 
-					replyTo(msg.Chat.ID, msg.MessageID, "Enabled saving messages")
-					log.Printf("Enable saved message for chat %d", msg.Chat.ID)
+					replyTo(msg, "Enabled saving messages")
+					cmdLog.Info("Saving enabled")
 				} else {
-					replyTo(msg.Chat.ID, msg.MessageID, "Saving message already enabled")
-					log.Printf("Saved message already enabled for chat %d", msg.Chat.ID)
+					replyTo(msg, "Saving message already enabled")
+					cmdLog.Info("Saving already enabled")
 				}
 				// create new configuration
 			} else {
-				CONFIGS[msg.Chat.ID] = NewChatConfig(msg.Chat.ID, 3600, msg.Chat.Title)
-				log.Printf("Create new configuration for chat %s", CONFIGS[msg.Chat.ID])
+				CONFIGS.Set(NewChatConfig(chat.ID, defaultChatTimeout, chat.Title))
+				cmdLog.WithField("chat_title", chat.Title).Info("New chat configuration created")
 
 				// save /on command message
-				NewMessage(msg.Chat.ID, msg.MessageID, msg.Date)
+				if inChat {
+					NewMessage(msg)
+				}
 
-				replyTo(msg.Chat.ID, msg.MessageID,
+				replyTo(msg,
 					"Create new configuration, default message timeout 1 hour")
 			}
 		case "off":
-			if CONFIGS.ExistAndEnable(msg.Chat.ID) {
-				var replyMsg *tgbotapi.Message
+			if CONFIGS.ExistAndEnable(chat.ID) && threadID != 0 {
+				// disable saving for topic, topic messages are kept
+				if CONFIGS.Get(chat.ID).ChangeTopicStatus(threadID, false) {
+					replyTo(msg, "Disabled saving topic messages")
+					cmdLog.WithField("thread_id", threadID).Info("Saving disabled for topic")
+				}
+			} else if CONFIGS.ExistAndEnable(chat.ID) {
+				var replyMsg *tBotMessage
 
-				if CONFIGS[msg.Chat.ID].ChangeStatus(false) {
-					replyMsg = replyTo(msg.Chat.ID, msg.MessageID, "Disabled saving messages")
-					log.Printf("Disable saved message for chat %s", CONFIGS[msg.Chat.ID])
+				if CONFIGS.Get(chat.ID).ChangeStatus(false) {
+					replyMsg = replyTo(msg, "Disabled saving messages")
+					cmdLog.Info("Saving disabled")
 				} else {
-					replyMsg = replyTo(msg.Chat.ID, msg.MessageID, "Saving message already disabled")
-					log.Printf("Saved message already disabled for chat %s", CONFIGS[msg.Chat.ID])
+					replyMsg = replyTo(msg, "Saving message already disabled")
+					cmdLog.Info("Saving already disabled")
 				}
 
 				// save reply message
-				NewMessage(replyMsg.Chat.ID, replyMsg.MessageID, replyMsg.Date)
+				if replyMsg != nil && inChat {
+					NewMessage(replyMsg)
+				}
 			}
 		case "timeout":
-			if CONFIGS.Exist(msg.Chat.ID) {
-				newTime, err := time.ParseDuration(msg.CommandArguments())
+			if CONFIGS.Exist(chat.ID) {
+				newTime, err := time.ParseDuration(args)
 				if err != nil {
-					log.Printf("WARNING: Invalid new timeout value: %s", err)
-					replyTo(msg.Chat.ID, msg.MessageID,
+					cmdLog.WithError(err).Warn("Invalid new timeout value")
+					replyTo(msg,
 						"Error! Invalid new timeout value. Send a /help command to get help")
 					break
 				}
-				if err := CONFIGS[msg.Chat.ID].ChangeTimeout(int(newTime.Seconds())); err != nil {
+
+				// command inside forum topic changes only topic timeout
+				if threadID != 0 {
+					err = CONFIGS.Get(chat.ID).ChangeTopicTimeout(threadID, int(newTime.Seconds()))
+				} else {
+					err = CONFIGS.Get(chat.ID).ChangeTimeout(int(newTime.Seconds()))
+				}
+				if err != nil {
 					replyMsg := fmt.Sprintf("Unable to set timeout! %s", err)
-					log.Printf("WARNING: %s", replyMsg)
-					replyTo(msg.Chat.ID, msg.MessageID, replyMsg)
+					cmdLog.WithError(err).Warn("Unable to set timeout")
+					replyTo(msg, replyMsg)
+					break
+				}
+				cmdLog.WithFields(log.Fields{"timeout": newTime, "thread_id": threadID}).Info(
+					"Timeout changed")
+				replyTo(msg, "Timeout changed")
+			}
+		case "topic":
+			if CONFIGS.Exist(chat.ID) {
+				if threadID == 0 {
+					replyTo(msg, "Error! The command works only inside a forum topic")
+					break
+				}
+
+				if strings.ToLower(strings.TrimSpace(args)) == "reset" {
+					CONFIGS.Get(chat.ID).ResetTopic(threadID)
+					cmdLog.WithField("thread_id", threadID).Info("Topic configuration reset")
+					replyTo(msg, "Topic uses chat settings now")
 					break
 				}
-				log.Printf("New timeout %s for chat %s", newTime, CONFIGS[msg.Chat.ID])
-				replyTo(msg.Chat.ID, msg.MessageID, "Timeout changed")
+				replyTo(msg, topicSettingHuman(CONFIGS.Get(chat.ID), threadID))
 			}
 		case "delete":
-			if CONFIGS.Exist(msg.Chat.ID) {
-				CONFIGS[msg.Chat.ID].DeleteAllChatMessages()
+			if CONFIGS.Exist(chat.ID) {
+				config := CONFIGS.Get(chat.ID)
+				if _, err := JOBS.Start(config, "delete", config.GetAllChatMessage(), msg, nil); err != nil {
+					replyTo(msg, fmt.Sprintf("Error! %s", err))
+				}
 			}
 		case "setting":
-			if CONFIGS.Exist(msg.Chat.ID) {
-				timeSec := fmt.Sprintf("%ds", CONFIGS[msg.Chat.ID].Timeout)
+			if CONFIGS.Exist(chat.ID) {
+				timeSec := fmt.Sprintf("%ds", CONFIGS.Get(chat.ID).Timeout)
 				timeHuman, _ := time.ParseDuration(timeSec)
 
 				status := "enable"
-				if !CONFIGS[msg.Chat.ID].Enabled {
+				if !CONFIGS.Get(chat.ID).Enabled {
 					status = "disable"
 				}
 
-				setting := fmt.Sprintf("Status: %s, Timeout: %s", status, timeHuman)
-				replyTo(msg.Chat.ID, msg.MessageID, setting)
+				setting := fmt.Sprintf(
+					"Status: %s, Timeout: %s, Active windows: %s, Archive: %s, Reset on edit: %s",
+					status, timeHuman, activeWindowsHuman(CONFIGS.Get(chat.ID)),
+					archiveHuman(CONFIGS.Get(chat.ID)), statusHuman(CONFIGS.Get(chat.ID).ResetOnEdit))
+				if threadID != 0 {
+					setting += "\n" + topicSettingHuman(CONFIGS.Get(chat.ID), threadID)
+				}
+				replyTo(msg, setting)
+			}
+		case "window":
+			if CONFIGS.Exist(chat.ID) {
+				args := strings.Fields(args)
+				if len(args) == 0 {
+					replyTo(msg,
+						"Active windows: "+activeWindowsHuman(CONFIGS.Get(chat.ID)))
+					break
+				}
+
+				windows := make([]tActiveWindow, 0, len(args))
+				if !(len(args) == 1 && strings.ToLower(args[0]) == "off") {
+					var parseErr error
+					for _, arg := range args {
+						window, err := parseActiveWindow(arg)
+						if err != nil {
+							parseErr = err
+							break
+						}
+						windows = append(windows, window)
+					}
+					if parseErr != nil {
+						cmdLog.WithError(parseErr).Warn("Invalid active window")
+						replyTo(msg,
+							fmt.Sprintf("Error! %s. Send a /help command to get help", parseErr))
+						break
+					}
+				}
+
+				CONFIGS.Get(chat.ID).ChangeActiveWindows(windows)
+				cmdLog.WithField("windows", activeWindowsHuman(CONFIGS.Get(chat.ID))).Info(
+					"Active windows changed")
+				replyTo(msg,
+					"Active windows changed: "+activeWindowsHuman(CONFIGS.Get(chat.ID)))
+			}
+		case "timezone":
+			if CONFIGS.Exist(chat.ID) {
+				zone := strings.TrimSpace(args)
+				if len(zone) == 0 {
+					replyTo(msg,
+						"Error! Time zone not set. Send a /help command to get help")
+					break
+				}
+				if err := CONFIGS.Get(chat.ID).ChangeTimeZone(zone); err != nil {
+					cmdLog.WithFields(log.Fields{"time_zone": zone, "error": err}).Warn("Invalid time zone")
+					replyTo(msg,
+						"Error! Invalid time zone. Send a /help command to get help")
+					break
+				}
+				cmdLog.WithField("time_zone", zone).Info("Time zone changed")
+				replyTo(msg, "Time zone changed")
+			}
+		case "archive":
+			if CONFIGS.Exist(chat.ID) {
+				args := strings.Fields(strings.ToLower(args))
+				if len(args) == 0 {
+					replyTo(msg, "Archive: "+archiveHuman(CONFIGS.Get(chat.ID)))
+					break
+				}
+
+				var archiveChatID int64
+				forward := false
+				if args[0] != "off" {
+					chatID, err := strconv.ParseInt(args[0], 10, 64)
+					validMode := len(args) == 1 ||
+						(len(args) == 2 && (args[1] == "copy" || args[1] == "forward"))
+					if err != nil || !validMode {
+						replyTo(msg, "Error! Invalid archive value. Send a /help command to get help")
+						break
+					}
+					archiveChatID = chatID
+					forward = len(args) == 2 && args[1] == "forward"
+				}
+
+				if err := CONFIGS.Get(chat.ID).ChangeArchive(archiveChatID, forward); err != nil {
+					replyMsg := fmt.Sprintf("Unable to set archive! %s", err)
+					cmdLog.WithError(err).Warn("Unable to set archive")
+					replyTo(msg, replyMsg)
+					break
+				}
+				cmdLog.WithField("archive_chat_id", archiveChatID).Info("Archive changed")
+				replyTo(msg, "Archive changed: "+archiveHuman(CONFIGS.Get(chat.ID)))
+			}
+		case "resetonedit":
+			if CONFIGS.Exist(chat.ID) {
+				var reset bool
+				switch strings.ToLower(strings.TrimSpace(args)) {
+				case "on":
+					reset = true
+				case "off":
+					reset = false
+				default:
+					replyTo(msg, "Reset on edit: "+statusHuman(CONFIGS.Get(chat.ID).ResetOnEdit))
+					continue
+				}
+
+				CONFIGS.Get(chat.ID).ChangeResetOnEdit(reset)
+				cmdLog.WithField("reset_on_edit", reset).Info("Reset on edit changed")
+				replyTo(msg, "Reset on edit changed: "+statusHuman(reset))
+			}
+		case "backfill":
+			if CONFIGS.Exist(chat.ID) {
+				// backfilled messages have no topic, topic settings would be ignored
+				if len(CONFIGS.Get(chat.ID).Topics) > 0 {
+					replyTo(msg, "Error! Backfill is not supported in chats with topic settings")
+					break
+				}
+
+				anchors := make([]tMessage, 0, 1)
+				lastID, maxID := 0, 0
+				if inChat {
+					// command message is the newest known message
+					anchors = append(anchors,
+						tMessage{ChatID: chat.ID, MsgID: msg.MessageID, TimeStamp: msg.Date})
+					lastID = msg.MessageID - 1
+					maxID = lastID
+				} else {
+					for _, message := range CONFIGS.Get(chat.ID).GetAllChatMessage() {
+						if message.MsgID > lastID {
+							lastID = message.MsgID
+						}
+					}
+				}
+
+				fromID, toID, since, err := parseBackfill(args, lastID, maxID, int(time.Now().Unix()))
+				if err != nil {
+					cmdLog.WithError(err).Warn("Invalid backfill value")
+					replyTo(msg, fmt.Sprintf("Error! %s. Send a /help command to get help", err))
+					break
+				}
+
+				registered := CONFIGS.Get(chat.ID).Backfill(fromID, toID, anchors, since)
+				cmdLog.WithFields(log.Fields{"from_id": fromID, "to_id": toID, "registered": registered}).Info(
+					"Backfill finished")
+				if registered == 0 && since != 0 {
+					replyTo(msg, "No messages of the duration found, their time can not be estimated. "+
+						"Use /backfill <from ID> <to ID>")
+					break
+				}
+				replyTo(msg, fmt.Sprintf("Registered %d messages for deleting", registered))
+			}
+		case "purge":
+			if CONFIGS.Exist(chat.ID) {
+				filter, err := parsePurgeFilter(args)
+				if err != nil {
+					cmdLog.WithError(err).Warn("Invalid purge filter")
+					replyTo(msg, fmt.Sprintf("Error! %s. Send a /help command to get help", err))
+					break
+				}
+
+				if inChat {
+					// messages from the user of replied message,
+					// topic messages are replies to the topic creation message
+					reply := msg.ReplyToMessage
+					if reply != nil && reply.From != nil && reply.MessageID != threadID {
+						filter.SenderID = reply.From.ID
+					}
+					// command message and bot replies are not purged
+					filter.BeforeID = msg.MessageID
+					// command sent inside forum topic purges only the topic
+					filter.ThreadID = threadID
+				}
+
+				if filter == (tPurgeFilter{BeforeID: filter.BeforeID, ThreadID: filter.ThreadID}) {
+					replyTo(msg, "Error! No purge filter. Use /delete to delete all messages")
+					break
+				}
+
+				config := CONFIGS.Get(chat.ID)
+				messages := filter.Select(config.GetAllChatMessage(), int(time.Now().Unix()))
+				cmdLog.WithFields(log.Fields{"filter": filter.String(), "selected": len(messages)}).Info(
+					"Purge messages selected")
+				if _, err := JOBS.Start(config, "purge", messages, msg, nil); err != nil {
+					replyTo(msg, fmt.Sprintf("Error! %s", err))
+				}
 			}
 		case "stop":
-			if CONFIGS.Exist(msg.Chat.ID) {
-				chatConfig := CONFIGS[msg.Chat.ID]
+			if CONFIGS.Exist(chat.ID) {
+				chatConfig := CONFIGS.Get(chat.ID)
 
-				// delete all saved message
-				chatConfig.DeleteAllChatMessages()
-				log.Printf("All chat %d messages have been deleted.", chatConfig.ChatID)
+				// delete all saved message, then the configuration
+				_, err := JOBS.Start(chatConfig, "stop", chatConfig.GetAllChatMessage(), msg,
+					func(job *tJob) {
+						if job.Cancelled() {
+							return
+						}
+						// job progress and summary replies are tracked too, no record must outlive the chat
+						for _, message := range chatConfig.GetAllChatMessage() {
+							if !message.Delete() {
+								message.Forget()
+							}
+						}
+						cmdLog.Info("All chat messages have been deleted")
 
-				chatConfig.DeleteConfig()
-				delete(CONFIGS, msg.Chat.ID)
-				log.Println("Chat configuration have been deleted.")
+						CONFIGS.Delete(chatConfig.ChatID)
+						cmdLog.Info("Chat configuration has been deleted")
 
-				replyTo(msg.Chat.ID, msg.MessageID, "Good by!")
+						replyTo(msg, "Good by!")
+					})
+				if err != nil {
+					replyTo(msg, fmt.Sprintf("Error! %s", err))
+				}
+			}
+		case "stats":
+			if CONFIGS.Exist(chat.ID) {
+				if strings.ToLower(strings.TrimSpace(args)) == "reset" {
+					// channel admin is checked for private chat commands
+					if inChat && (msg.From == nil || !isChatAdmin(chat.ID, msg.From.ID)) {
+						replyTo(msg, "Error! Only chat admins can reset statistics")
+						break
+					}
+					CONFIGS.Get(chat.ID).ResetStats()
+					cmdLog.Info("Statistics reset")
+					replyTo(msg, "Statistics reset")
+					break
+				}
+				replyTo(msg, statsHuman(CONFIGS.Get(chat.ID).Stats()))
+			}
+		case "cancel":
+			if CONFIGS.Exist(chat.ID) {
+				if !JOBS.Cancel(chat.ID) {
+					replyTo(msg, "No running jobs")
+					break
+				}
+				cmdLog.Info("Job cancelled")
 			}
 		case "ping":
-			replyTo(msg.Chat.ID, msg.MessageID, "pong")
+			replyTo(msg, "pong")
 		default:
-			log.Println("Receive unknown command:", command)
-			replyTo(msg.Chat.ID, msg.MessageID,
+			cmdLog.Warn("Unknown command received")
+			replyTo(msg,
 				"Unknown command. Please send 'help' for all possible commands.")
 		}
 	}
 }
 
+// human readable forum topic setting
+func topicSettingHuman(config *tChatConfig, threadID int) string {
+	topic, override := config.Topic(threadID)
+	timeHuman := time.Duration(topic.Timeout) * time.Second
+
+	status := "enable"
+	if !topic.Enabled {
+		status = "disable"
+	}
+
+	source := "chat settings"
+	if override {
+		source = "topic settings"
+	}
+	return fmt.Sprintf("Topic status: %s, Topic timeout: %s (%s)", status, timeHuman, source)
+}
+
+// human readable chat statistics
+func statsHuman(stats tChatStats) string {
+	types := make([]string, 0, len(stats.ByType))
+	for contentType, count := range stats.ByType {
+		types = append(types, fmt.Sprintf("%s %d", contentType, count))
+	}
+	sort.Strings(types)
+	if len(types) == 0 {
+		types = append(types, "none")
+	}
+
+	return fmt.Sprintf("Tracked: %d, Deleted: %d, Already missing: %d, Failed: %d\n"+
+		"Pending: %d, Oldest pending: %s\nBy type: %s",
+		stats.Tracked, stats.Deleted, stats.Missing, stats.Failed,
+		stats.Pending, time.Duration(stats.OldestPending)*time.Second,
+		strings.Join(types, ", "))
+}
+
+// human readable boolean setting
+func statusHuman(enabled bool) string {
+	if enabled {
+		return "enable"
+	}
+	return "disable"
+}
+
+// human readable archive setting
+func archiveHuman(config *tChatConfig) string {
+	if config.ArchiveChatID == 0 {
+		return "disable"
+	}
+
+	mode := "copy"
+	if config.ArchiveForward {
+		mode = "forward"
+	}
+	return fmt.Sprintf("%d (%s)", config.ArchiveChatID, mode)
+}
+
+// human readable active windows of chat
+func activeWindowsHuman(config *tChatConfig) string {
+	if len(config.ActiveWindows) == 0 {
+		return "always"
+	}
+
+	windows := make([]string, len(config.ActiveWindows))
+	for i, window := range config.ActiveWindows {
+		windows[i] = window.String()
+	}
+
+	zone := config.TimeZone
+	if len(zone) == 0 {
+		zone = "UTC"
+	}
+	return fmt.Sprintf("%s (%s)", strings.Join(windows, ", "), zone)
+}
+
+// chats where deleting is allowed at this moment
+func collectableChats(now time.Time) map[int64]bool {
+	collectable := make(map[int64]bool)
+	for _, config := range CONFIGS.List() {
+		collectable[config.ChatID] = config.CanCollect(now)
+		if !collectable[config.ChatID] {
+			chatLog(config.ChatID).Debug("Chat is outside its active windows, deleting deferred")
+		}
+	}
+	return collectable
+}
+
+// catch-up sweep of messages outdated while the bot was down,
+// deleting is spread over time to avoid flood limits
+func catchUpSweep(rate int) {
+	log.Info("Catch-up sweep started")
+	markGCHealth()
+	collectable := collectableChats(time.Now())
+
+	overdue := make([]tMessage, 0)
+	for _, message := range GetAllMessages(CONFIGS) {
+		if message.IsOutdated() && collectable[message.ChatID] {
+			overdue = append(overdue, message)
+		}
+	}
+
+	if len(overdue) == 0 {
+		log.Info("Catch-up sweep finished, no overdue messages")
+		return
+	}
+
+	// the most overdue messages are deleted first
+	sort.Slice(overdue, func(i, j int) bool {
+		return overdue[i].Deadline() < overdue[j].Deadline()
+	})
+	log.WithFields(log.Fields{"overdue": len(overdue), "rate": rate}).Info(
+		"Catch-up sweep deleting overdue messages")
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+
+	started := time.Now()
+	for n, message := range overdue {
+		<-ticker.C
+		message.Delete()
+
+		if done := n + 1; done%100 == 0 || done == len(overdue) {
+			markGCHealth()
+			log.WithFields(log.Fields{
+				"done":    done,
+				"overdue": len(overdue),
+				"elapsed": time.Since(started).Round(time.Second).String(),
+			}).Info("Catch-up sweep progress")
+		}
+	}
+	log.Info("Catch-up sweep finished")
+}
+
+// request to run garbage collector cycle without waiting for timeout
+var gcTrigger = make(chan struct{}, 1)
+
+// request garbage collector cycle, false if it is already requested
+func triggerGarbageCollector() bool {
+	select {
+	case gcTrigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
 // garbage collector for deleting older messages
-func garbageCollectorHandler(timeout time.Duration) {
-	log.Println("Start garbage collector handler")
+func garbageCollectorHandler() {
+	log.Info("Start garbage collector handler")
+
+	// the first pass after start deletes the backlog slowly
+	if CONFIGS.Len() > 0 {
+		catchUpSweep(currentSetting().catchUpRate)
+	}
+
 	for true {
-		log.Println("Garbage collector awake")
-		if len(CONFIGS) > 0 {
+		// interval is read every cycle to apply reloaded setting
+		timeout := currentSetting().gcTimeout
+		log.WithField("timeout", int64(timeout)).Debug("Garbage collector falls asleep")
+		select {
+		case <-time.After(timeout * time.Second):
+			log.Debug("Garbage collector awake")
+		case <-gcTrigger:
+			log.Info("Garbage collector awake by request")
+		}
+
+		started := time.Now()
+		if CONFIGS.Len() > 0 {
+			collectable := collectableChats(started)
+			pending := make(map[int64]int)
 			for _, message := range GetAllMessages(CONFIGS) {
-				if message.IsOutdated() {
-					message.Delete()
+				if message.IsOutdated() && collectable[message.ChatID] && message.Delete() {
+					continue
 				}
+				pending[message.ChatID]++
 			}
+			setPendingMetrics(pending)
 		} else {
-			log.Println("No chat configurations")
+			log.Debug("No chat configurations")
 		}
-		log.Printf("Garbage collector falls asleep for %d seconds...", timeout)
-		time.Sleep(timeout * time.Second)
+		metricCycleDuration.Observe(time.Since(started).Seconds())
+		markGCHealth()
 	}
 }
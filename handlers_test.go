@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseBackfill(t *testing.T) {
+	storeSetting(&botSetting{backfillLimit: 100})
+	now := 1000000
+
+	tests := []struct {
+		name    string
+		args    string
+		lastID  int
+		maxID   int
+		fromID  int
+		toID    int
+		since   int
+		invalid bool
+	}{
+		{name: "range", args: "10 20", lastID: 500, fromID: 10, toID: 20},
+		{name: "single message range", args: "7 7", fromID: 7, toID: 7},
+		{name: "range at limit", args: "1 100", fromID: 1, toID: 100},
+		{name: "range over limit", args: "1 101", invalid: true},
+		{name: "reversed range", args: "20 10", invalid: true},
+		{name: "zero ID", args: "0 10", invalid: true},
+		{name: "not a number", args: "a 10", invalid: true},
+		{name: "range before command", args: "450 499", lastID: 499, maxID: 499, fromID: 450, toID: 499},
+		{name: "range with command", args: "450 500", lastID: 499, maxID: 499, invalid: true},
+		{name: "range after command", args: "600 610", lastID: 499, maxID: 499, invalid: true},
+		{name: "range after tracked messages", args: "600 610", lastID: 499, fromID: 600, toID: 610},
+		{name: "duration", args: "1h", lastID: 500, fromID: 401, toID: 500, since: now - 3600},
+		{name: "duration near first message", args: "30m", lastID: 40, fromID: 1, toID: 40, since: now - 1800},
+		{name: "duration without messages", args: "1h", lastID: 0, invalid: true},
+		{name: "negative duration", args: "-1h", lastID: 500, invalid: true},
+		{name: "invalid duration", args: "day", lastID: 500, invalid: true},
+		{name: "no arguments", args: "", invalid: true},
+		{name: "too many arguments", args: "1 2 3", invalid: true},
+	}
+
+	for _, test := range tests {
+		fromID, toID, since, err := parseBackfill(test.args, test.lastID, test.maxID, now)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %d-%d since %d", test.name, fromID, toID, since)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		if fromID != test.fromID || toID != test.toID || since != test.since {
+			t.Errorf("%s: got %d-%d since %d, expected %d-%d since %d",
+				test.name, fromID, toID, since, test.fromID, test.toID, test.since)
+		}
+	}
+}
+
+func TestParsePurgeFilter(t *testing.T) {
+	tests := []struct {
+		args    string
+		filter  tPurgeFilter
+		invalid bool
+	}{
+		{args: "", filter: tPurgeFilter{}},
+		{args: "last 10", filter: tPurgeFilter{Last: 10}},
+		{args: "older 2h", filter: tPurgeFilter{OlderThan: 7200}},
+		{args: "TYPE Photo", filter: tPurgeFilter{ContentType: "photo"}},
+		{args: "last 5 older 30m type sticker", filter: tPurgeFilter{Last: 5, OlderThan: 1800, ContentType: "sticker"}},
+		{args: "last", invalid: true},
+		{args: "last 0", invalid: true},
+		{args: "last -1", invalid: true},
+		{args: "last many", invalid: true},
+		{args: "older -1h", invalid: true},
+		{args: "older week", invalid: true},
+		{args: "sender 1", invalid: true},
+	}
+
+	for _, test := range tests {
+		filter, err := parsePurgeFilter(test.args)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%q: expected error, got %s", test.args, filter)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.args, err)
+			continue
+		}
+		if filter != test.filter {
+			t.Errorf("%q: got %s, expected %s", test.args, filter, test.filter)
+		}
+	}
+}
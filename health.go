@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// unix nano time of the last successful getUpdates and garbage collector cycle
+var (
+	healthStarted   = time.Now().UnixNano()
+	healthUpdatesAt int64
+	healthGCAt      int64
+)
+
+// mark successful getUpdates request
+func markUpdatesHealth() {
+	atomic.StoreInt64(&healthUpdatesAt, time.Now().UnixNano())
+}
+
+// mark garbage collector activity
+func markGCHealth() {
+	atomic.StoreInt64(&healthGCAt, time.Now().UnixNano())
+}
+
+// health check report type
+type tHealthReport struct {
+	Status         string   `json:"status"`
+	Redis          string   `json:"redis,omitempty"`
+	LastUpdates    string   `json:"last_updates,omitempty"`
+	LastUpdatesAge string   `json:"last_updates_age,omitempty"`
+	LastGC         string   `json:"last_gc,omitempty"`
+	LastGCAge      string   `json:"last_gc_age,omitempty"`
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// method checking that the event happened not earlier than threshold,
+// zero time is replaced by the start time unless the event is required
+func (r *tHealthReport) checkAge(name string, at int64, threshold time.Duration, required bool) (string, string) {
+	if at == 0 {
+		if required {
+			r.Errors = append(r.Errors, name+" never succeeded")
+			return "", ""
+		}
+		at = healthStarted
+	}
+
+	moment := time.Unix(0, at)
+	age := time.Since(moment)
+	if age > threshold {
+		r.Errors = append(r.Errors, name+" is stale")
+	}
+	return moment.UTC().Format(time.RFC3339), age.Round(time.Second).String()
+}
+
+// build health report, ready check also requires Redis and the first received updates
+func healthReport(ready bool) tHealthReport {
+	var report tHealthReport
+	setting := currentSetting()
+
+	updatesAt := atomic.LoadInt64(&healthUpdatesAt)
+	report.LastUpdates, report.LastUpdatesAge = report.checkAge(
+		"getUpdates", updatesAt, time.Duration(setting.healthUpdatesTimeout)*time.Second, ready)
+
+	gcAt := atomic.LoadInt64(&healthGCAt)
+	report.LastGC, report.LastGCAge = report.checkAge(
+		"garbage collector", gcAt, time.Duration(setting.healthGCTimeout)*time.Second, false)
+
+	if ready {
+		report.Redis = "ok"
+		if err := DB.Ping().Err(); err != nil {
+			report.Redis = err.Error()
+			report.Errors = append(report.Errors, "redis ping failed")
+		}
+	}
+
+	report.Status = "ok"
+	if len(report.Errors) > 0 {
+		report.Status = "fail"
+	}
+	return report
+}
+
+// write health report with 503 status on failure
+func writeHealthReport(w http.ResponseWriter, report tHealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// liveness check: receiving updates and garbage collector are not stuck
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, healthReport(false))
+}
+
+// readiness check: liveness, Redis is available and updates are received
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, healthReport(true))
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// start HTTP listener with service endpoints, admin API is enabled by token
+func startHTTPServer(address, adminToken string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	if len(adminToken) > 0 {
+		mux.Handle(adminAPIPrefix, adminAPIHandler(adminToken))
+		log.Info("Admin API enabled")
+	}
+
+	log.WithField("address", address).Info("Start HTTP listener")
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.WithError(err).Fatal("HTTP listener failed")
+		}
+	}()
+}
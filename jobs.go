@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// how often job progress message is edited
+const jobProgressInterval = 3 * time.Second
+
+// background deleting job type
+type tJob struct {
+	mu        sync.Mutex
+	ID        int
+	ChatID    int64
+	Name      string
+	Total     int
+	Deleted   int
+	Failed    int
+	Started   time.Time
+	cancel    chan struct{}
+	cancelled bool
+}
+
+// method getting job progress text
+func (job *tJob) String() string {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return fmt.Sprintf("/%s: deleted %d/%d, failed %d",
+		job.Name, job.Deleted, job.Total, job.Failed)
+}
+
+// method checking that job was cancelled
+func (job *tJob) Cancelled() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.cancelled
+}
+
+// method deleting messages and reporting progress in reply to msg
+func (job *tJob) run(messages []tMessage, msg *tBotMessage) {
+	jobLog := chatLog(job.ChatID).WithFields(log.Fields{"job_id": job.ID, "command": job.Name})
+	jobLog.WithField("total", job.Total).Info("Job started")
+
+	// job started without command message reports only to log
+	var progress *tBotMessage
+	if msg != nil {
+		progress = replyTo(msg, job.String())
+	}
+	lastEdit := time.Now()
+
+	for _, message := range messages {
+		select {
+		case <-job.cancel:
+			job.mu.Lock()
+			job.cancelled = true
+			job.mu.Unlock()
+		default:
+		}
+		if job.Cancelled() {
+			break
+		}
+
+		deleted := message.Delete()
+		job.mu.Lock()
+		if deleted {
+			job.Deleted++
+		} else {
+			job.Failed++
+		}
+		job.mu.Unlock()
+
+		if progress != nil && time.Since(lastEdit) >= jobProgressInterval {
+			editMessage(progress, job.String())
+			lastEdit = time.Now()
+		}
+	}
+
+	status := "finished"
+	if job.Cancelled() {
+		status = "cancelled"
+	}
+	summary := fmt.Sprintf("%s %s in %s", job, status, time.Since(job.Started).Round(time.Second))
+	jobLog.WithFields(log.Fields{
+		"deleted": job.Deleted,
+		"failed":  job.Failed,
+		"status":  status,
+	}).Info("Job finished")
+
+	if msg == nil {
+		return
+	}
+	if progress == nil || editMessage(progress, summary) != nil {
+		replyTo(msg, summary)
+	}
+}
+
+// background jobs registry, one running job per chat
+type tJobs struct {
+	mu     sync.Mutex
+	lastID int
+	jobs   map[int64]*tJob
+}
+
+var JOBS = &tJobs{jobs: make(map[int64]*tJob)}
+
+// method starting job deleting messages of chat, progress is reported in reply to msg if set,
+// onFinish is called after the job
+func (j *tJobs) Start(config *tChatConfig, name string, messages []tMessage,
+	msg *tBotMessage, onFinish func(job *tJob)) (*tJob, error) {
+
+	j.mu.Lock()
+	if running, ok := j.jobs[config.ChatID]; ok {
+		j.mu.Unlock()
+		return nil, fmt.Errorf("%s job is already running, send /cancel to stop it", running.Name)
+	}
+
+	j.lastID++
+	job := &tJob{
+		ID:      j.lastID,
+		ChatID:  config.ChatID,
+		Name:    name,
+		Total:   len(messages),
+		Started: time.Now(),
+		cancel:  make(chan struct{}),
+	}
+	j.jobs[config.ChatID] = job
+	j.mu.Unlock()
+
+	go func() {
+		job.run(messages, msg)
+
+		j.mu.Lock()
+		delete(j.jobs, job.ChatID)
+		j.mu.Unlock()
+
+		if onFinish != nil {
+			onFinish(job)
+		}
+	}()
+	return job, nil
+}
+
+// method cancelling running job of chat, false if there is no job
+func (j *tJobs) Cancel(chatID int64) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[chatID]
+	if !ok {
+		return false
+	}
+
+	select {
+	case <-job.cancel:
+		// already cancelled
+	default:
+		close(job.cancel)
+	}
+	return true
+}
+
+// method getting running job of chat, nil if there is no job
+func (j *tJobs) Get(chatID int64) *tJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.jobs[chatID]
+}
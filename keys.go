@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+)
+
+// prefix of all bot keys in Redis, bots sharing one Redis DB use different prefixes
+var KEYPREFIX string
+
+// characters not allowed in key prefix, they have special meaning in key filters
+const keyPrefixReserved = "*?[]\\: "
+
+// prefixed Redis key
+func dbKey(name string) string {
+	return KEYPREFIX + ":" + name
+}
+
+// key of saved message
+func messageKey(chatID int64, msgID int) string {
+	return dbKey(fmt.Sprintf("msg_%d_%d", chatID, msgID))
+}
+
+// filter of all saved messages of chat
+func chatMessagesFilter(chatID int64) string {
+	return dbKey(fmt.Sprintf("msg_%d_*", chatID))
+}
+
+// filter of all saved messages
+func messagesFilter() string {
+	return dbKey("msg_*")
+}
+
+// key of chat configuration
+func chatKey(chatID int64) string {
+	return dbKey(fmt.Sprintf("chat_%d", chatID))
+}
+
+// filter of all chat configurations
+func chatsFilter() string {
+	return dbKey("chat_*")
+}
+
+// key of chat collection statistics
+func statsKey(chatID int64) string {
+	return dbKey(fmt.Sprintf("stats_%d", chatID))
+}
+
+// key of the next update offset
+func updateOffsetKey() string {
+	return dbKey("update_offset")
+}
+
+// key of storage schema version
+func schemaVersionKey() string {
+	return dbKey("schema_version")
+}
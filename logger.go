@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// setup log format and level
+func setupLogger(format, level string) error {
+	logLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "logfmt", "text":
+		log.SetFormatter(&log.TextFormatter{DisableColors: true, FullTimestamp: true})
+	default:
+		return fmt.Errorf("unknown log format %s", format)
+	}
+
+	log.SetLevel(logLevel)
+	return nil
+}
+
+// logger with chat ID field
+func chatLog(chatID int64) *log.Entry {
+	return log.WithField("chat_id", chatID)
+}
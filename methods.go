@@ -1,25 +1,58 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
-	"log"
+	log "github.com/sirupsen/logrus"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // bot message type
 type tMessage struct {
-	chatConfig *tChatConfig
-	ChatID     int64
-	MsgID      int
-	TimeStamp  int
+	chatConfig  *tChatConfig
+	ChatID      int64
+	ThreadID    int
+	MsgID       int
+	TimeStamp   int
+	SenderID    int
+	ContentType string
+	// time of the last edit, 0 if message was not edited
+	EditTimeStamp int
+	// message registered by backfill with estimated timestamp
+	Backfilled bool
+	// message copied to archive chat, the copy is not repeated when deleting is retried
+	Archived bool
 }
 
-// method delete message from Redis and telegram
-func (msg tMessage) Delete() {
+// method delete message from Redis and telegram, false if message will be deleted later
+func (msg tMessage) Delete() bool {
+	// copy message to archive chat, the original is kept until the copy succeeds
+	if msg.chatConfig.ArchiveChatID != 0 && !msg.Archived {
+		resp, err := copyMessage(msg.chatConfig.ArchiveChatID, msg.ChatID, msg.MsgID,
+			msg.chatConfig.ArchiveForward)
+		switch {
+		case err == nil:
+			msg.SaveArchived()
+		case isMessageNotFound(resp):
+			// backfilled message ID may never have existed
+			if !msg.Backfilled {
+				msg.logger().WithError(err).Warn("Message not found, nothing to archive")
+			}
+		case isMessageNotCopyable(resp):
+			// retrying does not help, the message is deleted without copy
+			msg.logger().WithError(err).Warn("Message can not be archived, it is deleted without copy")
+		default:
+			msg.logger().WithError(err).Error("Failed to archive message, it will be deleted later")
+			msg.recordResult(auditArchiveFailed, resp.ErrorCode, err)
+			return false
+		}
+	}
+
 	// delete from telegram
 	delMsg := tgbotapi.DeleteMessageConfig{
 		MessageID: msg.MsgID,
@@ -28,30 +61,93 @@ func (msg tMessage) Delete() {
 	resp, err := BOT.DeleteMessage(delMsg)
 
 	if err != nil {
-		switch resp.ErrorCode {
-		case 400:
-			log.Printf("Warning: %s from chat %s", resp.Description, msg.chatConfig)
+		switch {
+		case isMessageNotFound(resp):
+			// backfilled message ID may never have existed
+			if !msg.Backfilled {
+				msg.logger().WithError(err).Warn("Message not found, it is skipped")
+			}
+			msg.recordResult(auditNotFound, resp.ErrorCode, err)
+		case resp.ErrorCode == 400:
+			// message is too old or the bot has no rights, retrying does not help
+			msg.logger().WithError(err).Warn("Message can not be deleted, it is skipped")
+			msg.recordResult(auditNotDeletable, resp.ErrorCode, err)
 		default:
-			log.Printf("Error: %s. The message %s will be deleted later from chat %s.",
-				err, msg, msg.chatConfig)
-			return
+			msg.logger().WithError(err).Error("Failed to delete message, it will be deleted later")
+			msg.recordResult(auditFailed, resp.ErrorCode, err)
+			return false
 		}
+	} else {
+		msg.recordResult(auditDeleted, 0, nil)
+		msg.logger().Info("Message deleted")
 	}
 
-	log.Printf("The message %s has been deleted from chat %s",
-		msg, msg.chatConfig)
-
 	// delete from Redis
-	key := fmt.Sprintf("msg_%d_%d", msg.ChatID, msg.MsgID)
+	key := messageKey(msg.ChatID, msg.MsgID)
 	if err := DeleteFromDB(key); err != nil {
-		log.Printf("Error: message %s has not been deleted from redis: %s", msg, err)
+		msg.logger().WithError(err).Error("Failed to delete message from Redis")
+	}
+	return true
+}
+
+// method deleting message from Redis only, the message is kept in telegram
+func (msg tMessage) Forget() bool {
+	key := messageKey(msg.ChatID, msg.MsgID)
+	return DeleteFromDB(key) == nil
+}
+
+// method recording deleting result to statistics, metrics and audit log
+func (msg tMessage) recordResult(result string, errorCode int, err error) {
+	switch result {
+	case auditDeleted:
+		countStat(msg.ChatID, statDeleted)
+	case auditNotFound:
+		countStat(msg.ChatID, statMissing)
+	default:
+		countStat(msg.ChatID, statFailed)
+	}
+	metricDeletions.WithLabelValues(result, strconv.Itoa(errorCode)).Inc()
+	msg.audit(result, err)
+}
+
+// method writing deleting result to audit log
+func (msg tMessage) audit(result string, err error) {
+	if AUDIT == nil {
+		return
+	}
+
+	record := tAuditRecord{
+		ChatID:      msg.ChatID,
+		MsgID:       msg.MsgID,
+		SenderID:    msg.SenderID,
+		ContentType: msg.ContentType,
+		TimeStamp:   msg.TimeStamp,
+		DeletedAt:   time.Now().Unix(),
+		Result:      result,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	AUDIT.Write(record)
+}
+
+// method getting time when message becomes outdated
+func (msg tMessage) Deadline() int {
+	timestamp := msg.TimeStamp
+	// expiry clock is restarted by edit
+	if msg.chatConfig.ResetOnEdit && msg.EditTimeStamp > timestamp {
+		timestamp = msg.EditTimeStamp
 	}
+	return timestamp + msg.chatConfig.TimeoutFor(msg.ThreadID)
 }
 
 // aging test message method
 func (msg tMessage) IsOutdated() bool {
-	delta := int(time.Now().Unix()) - msg.TimeStamp
-	if delta >= msg.chatConfig.Timeout {
+	// messages of disabled topic are kept
+	if !msg.chatConfig.IsTopicEnabled(msg.ThreadID) {
+		return false
+	}
+	if int(time.Now().Unix()) >= msg.Deadline() {
 		return true
 	}
 	return false
@@ -59,64 +155,292 @@ func (msg tMessage) IsOutdated() bool {
 
 // method saving message to Redis
 func (msg tMessage) Save() bool {
-	jsonMessage, _ := json.Marshal(msg)
-	key := fmt.Sprintf("msg_%d_%d", msg.ChatID, msg.MsgID)
+	jsonMessage, _ := marshalRecord(msg)
+	key := messageKey(msg.ChatID, msg.MsgID)
 	if err := SaveToDB(key, jsonMessage); err != nil {
-		log.Println("Failed to save message:", err)
+		msg.logger().WithError(err).Error("Failed to save message")
 		return false
 	}
 	return true
 }
 
+// method saving edit time of already saved message
+func (msg tMessage) SaveEdit(editTimeStamp int) bool {
+	msg.EditTimeStamp = editTimeStamp
+	jsonMessage, _ := marshalRecord(msg)
+	key := messageKey(msg.ChatID, msg.MsgID)
+	// message may be deleted by garbage collector meanwhile
+	updated, err := UpdateInDB(key, jsonMessage)
+	if err != nil {
+		msg.logger().WithError(err).Error("Failed to save message edit")
+		return false
+	}
+	return updated
+}
+
+// method marking already saved message as copied to archive chat
+func (msg tMessage) SaveArchived() bool {
+	msg.Archived = true
+	jsonMessage, _ := marshalRecord(msg)
+	key := messageKey(msg.ChatID, msg.MsgID)
+	// message may be deleted by other job meanwhile
+	updated, err := UpdateInDB(key, jsonMessage)
+	if err != nil {
+		msg.logger().WithError(err).Error("Failed to save message archive status")
+		return false
+	}
+	return updated
+}
+
+// logger with message fields
+func (msg tMessage) logger() *log.Entry {
+	return chatLog(msg.ChatID).WithField("msg_id", msg.MsgID)
+}
+
 func (msg tMessage) String() string {
 	return strconv.Itoa(msg.MsgID)
 }
 
 // chat configuration type
 type tChatConfig struct {
-	ChatID    int64
-	Timeout   int
-	ChatTitle string
-	Enabled   bool
+	ChatID        int64
+	Timeout       int
+	ChatTitle     string
+	Enabled       bool
+	ActiveWindows []tActiveWindow
+	TimeZone      string
+	Topics        map[int]*tTopicConfig
+	// chat receiving copies of messages before deleting, 0 if archive disabled
+	ArchiveChatID  int64
+	ArchiveForward bool
+	// restart message expiry clock on edit
+	ResetOnEdit bool
+}
+
+// forum topic configuration type, overrides chat configuration
+type tTopicConfig struct {
+	// 0 if chat timeout is used
+	Timeout int
+	Enabled bool
 }
 
 func (cnf tChatConfig) String() string {
 	return cnf.ChatTitle
 }
 
+// method copying configuration, the copy shares nothing with the original
+func (cnf tChatConfig) clone() *tChatConfig {
+	clone := cnf
+	if cnf.ActiveWindows != nil {
+		clone.ActiveWindows = append([]tActiveWindow(nil), cnf.ActiveWindows...)
+	}
+	if cnf.Topics != nil {
+		clone.Topics = make(map[int]*tTopicConfig, len(cnf.Topics))
+		for threadID, topic := range cnf.Topics {
+			topicClone := *topic
+			clone.Topics[threadID] = &topicClone
+		}
+	}
+	return &clone
+}
+
 // method saving configuration to Redis
 func (cnf tChatConfig) Save() bool {
-	jsonConfig, _ := json.Marshal(cnf)
-	key := fmt.Sprintf("chat_%d", cnf.ChatID)
+	jsonConfig, _ := marshalRecord(cnf)
+	key := chatKey(cnf.ChatID)
 	if err := SaveToDB(key, jsonConfig); err != nil {
-		log.Println("Failed to save message:", err)
+		chatLog(cnf.ChatID).WithError(err).Error("Failed to save chat configuration")
 		return false
 	}
 	return true
 }
 
-// change method garbage collector timeout in configuration
-func (cnf *tChatConfig) ChangeTimeout(timeout int) error {
-	if timeout > 0 && timeout <= SETTING.timeoutLimit {
-		cnf.Timeout = timeout
-		cnf.Save()
+// checking timeout value limits
+func checkTimeout(timeout int) error {
+	timeoutLimit := currentSetting().timeoutLimit
+	if timeout > 0 && timeout <= timeoutLimit {
 		return nil
 	}
 
 	if timeout <= 0 {
 		return errors.New("timeout must be greater than 0")
-	} else if timeout >= SETTING.timeoutLimit {
-		maxTimeHuman, _ := time.ParseDuration(fmt.Sprintf("%ds", SETTING.timeoutLimit))
+	} else if timeout >= timeoutLimit {
+		maxTimeHuman, _ := time.ParseDuration(fmt.Sprintf("%ds", timeoutLimit))
 		return errors.New(fmt.Sprintf("maximum timeout value: %s", maxTimeHuman))
 	}
-	log.Printf("ERROR: unknown timeout error, raw timeout: %d", timeout)
+	log.WithField("timeout", timeout).Error("Unknown timeout error")
 	return errors.New("unknown timeout error")
 }
 
+// change method garbage collector timeout in configuration
+func (cnf *tChatConfig) ChangeTimeout(timeout int) error {
+	if err := checkTimeout(timeout); err != nil {
+		return err
+	}
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		config.Timeout = timeout
+		return nil
+	})
+}
+
+// method getting topic configuration, chat configuration is used for 0 thread,
+// returns false if topic timeout is inherited from the chat
+func (cnf tChatConfig) Topic(threadID int) (tTopicConfig, bool) {
+	topic := tTopicConfig{Timeout: cnf.Timeout, Enabled: true}
+	if override, ok := cnf.Topics[threadID]; ok && threadID != 0 {
+		topic.Enabled = override.Enabled
+		if override.Timeout != 0 {
+			topic.Timeout = override.Timeout
+			return topic, true
+		}
+	}
+	return topic, false
+}
+
+// method getting topic or chat timeout
+func (cnf tChatConfig) TimeoutFor(threadID int) int {
+	topic, _ := cnf.Topic(threadID)
+	return topic.Timeout
+}
+
+// method checking topic enable status
+func (cnf tChatConfig) IsTopicEnabled(threadID int) bool {
+	topic, _ := cnf.Topic(threadID)
+	return topic.Enabled
+}
+
+// method creating topic override if it not exist
+func (cnf *tChatConfig) topicOverride(threadID int) *tTopicConfig {
+	if cnf.Topics == nil {
+		cnf.Topics = make(map[int]*tTopicConfig)
+	}
+	if _, ok := cnf.Topics[threadID]; !ok {
+		cnf.Topics[threadID] = &tTopicConfig{Enabled: true}
+	}
+	return cnf.Topics[threadID]
+}
+
+// change method topic timeout in configuration
+func (cnf *tChatConfig) ChangeTopicTimeout(threadID, timeout int) error {
+	if err := checkTimeout(timeout); err != nil {
+		return err
+	}
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		config.topicOverride(threadID).Timeout = timeout
+		return nil
+	})
+}
+
+// enable\disable saving topic message method
+func (cnf *tChatConfig) ChangeTopicStatus(threadID int, enabled bool) bool {
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		config.topicOverride(threadID).Enabled = enabled
+		return nil
+	}) == nil
+}
+
+// delete topic override method
+func (cnf *tChatConfig) ResetTopic(threadID int) bool {
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		delete(config.Topics, threadID)
+		return nil
+	}) == nil
+}
+
 // enable\disable saving message method
 func (cnf *tChatConfig) ChangeStatus(enabled bool) bool {
-	cnf.Enabled = enabled
-	return cnf.Save()
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		config.Enabled = enabled
+		return nil
+	}) == nil
+}
+
+// change method timeout and status in configuration at once, nil values are not changed
+func (cnf *tChatConfig) ChangeTimeoutAndStatus(timeout *int, enabled *bool) error {
+	if timeout != nil {
+		if err := checkTimeout(*timeout); err != nil {
+			return err
+		}
+	}
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		if timeout != nil {
+			config.Timeout = *timeout
+		}
+		if enabled != nil {
+			config.Enabled = *enabled
+		}
+		return nil
+	})
+}
+
+// change method archive chat in configuration
+func (cnf *tChatConfig) ChangeArchive(archiveChatID int64, forward bool) error {
+	if archiveChatID == cnf.ChatID {
+		return errors.New("archive chat must differ from the chat")
+	}
+	if archiveChatID != 0 {
+		if _, err := BOT.GetChat(tgbotapi.ChatConfig{ChatID: archiveChatID}); err != nil {
+			return fmt.Errorf("archive chat is not available: %s", err)
+		}
+	}
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		config.ArchiveChatID = archiveChatID
+		config.ArchiveForward = forward
+		return nil
+	})
+}
+
+// enable\disable restarting expiry clock on edit method
+func (cnf *tChatConfig) ChangeResetOnEdit(reset bool) bool {
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		config.ResetOnEdit = reset
+		return nil
+	}) == nil
+}
+
+// change method active windows in configuration
+func (cnf *tChatConfig) ChangeActiveWindows(windows []tActiveWindow) bool {
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		config.ActiveWindows = windows
+		return nil
+	}) == nil
+}
+
+// change method time zone of active windows in configuration
+func (cnf *tChatConfig) ChangeTimeZone(name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return err
+	}
+	return CONFIGS.Update(cnf.ChatID, func(config *tChatConfig) error {
+		config.TimeZone = name
+		return nil
+	})
+}
+
+// method getting chat time zone location
+func (cnf tChatConfig) Location() *time.Location {
+	location, err := time.LoadLocation(cnf.TimeZone)
+	if err != nil {
+		chatLog(cnf.ChatID).WithField("time_zone", cnf.TimeZone).Warn("Invalid time zone, UTC is used")
+		return time.UTC
+	}
+	return location
+}
+
+// method checking that the garbage collector may delete messages now
+func (cnf tChatConfig) CanCollect(now time.Time) bool {
+	if len(cnf.ActiveWindows) == 0 {
+		return true
+	}
+
+	localNow := now.In(cnf.Location())
+	minute := localNow.Hour()*60 + localNow.Minute()
+	for _, window := range cnf.ActiveWindows {
+		if window.Contains(minute) {
+			return true
+		}
+	}
+	return false
 }
 
 // method checking enable status
@@ -126,10 +450,54 @@ func (cnf tChatConfig) IsEnabled() bool {
 
 // delete configuration method
 func (cnf tChatConfig) DeleteConfig() bool {
-	key := fmt.Sprintf("chat_%d", cnf.ChatID)
+	key := chatKey(cnf.ChatID)
 	err := DeleteFromDB(key)
 	if err != nil {
-		log.Printf("Failed to delete chat %s configuration: %s", cnf, err)
+		chatLog(cnf.ChatID).WithError(err).Error("Failed to delete chat configuration")
+		return false
+	}
+	cnf.ResetStats()
+	return true
+}
+
+// method getting chat collection statistics
+func (cnf tChatConfig) Stats() tChatStats {
+	stats := tChatStats{ByType: make(map[string]int64)}
+
+	counters, err := LoadHashFromDB(statsKey(cnf.ChatID))
+	if err != nil {
+		chatLog(cnf.ChatID).WithError(err).Error("Failed to load chat statistics")
+	}
+	for field, value := range counters {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		switch {
+		case field == statTracked:
+			stats.Tracked = count
+		case field == statDeleted:
+			stats.Deleted = count
+		case field == statMissing:
+			stats.Missing = count
+		case field == statFailed:
+			stats.Failed = count
+		case strings.HasPrefix(field, statTypePrefix):
+			stats.ByType[strings.TrimPrefix(field, statTypePrefix)] = count
+		}
+	}
+
+	now := int(time.Now().Unix())
+	for _, message := range cnf.GetAllChatMessage() {
+		stats.Pending++
+		if age := now - message.TimeStamp; age > stats.OldestPending {
+			stats.OldestPending = age
+		}
+	}
+	return stats
+}
+
+// method resetting chat collection statistics
+func (cnf tChatConfig) ResetStats() bool {
+	if err := DeleteFromDB(statsKey(cnf.ChatID)); err != nil {
+		chatLog(cnf.ChatID).WithError(err).Error("Failed to reset chat statistics")
 		return false
 	}
 	return true
@@ -137,94 +505,405 @@ func (cnf tChatConfig) DeleteConfig() bool {
 
 // method getting all message for chat
 func (cnf tChatConfig) GetAllChatMessage() []tMessage {
-	key := fmt.Sprintf("msg_%d_*", cnf.ChatID)
+	key := chatMessagesFilter(cnf.ChatID)
 	jsonMessages, err := LoadFromDB(key)
 	if err != nil {
-		log.Printf("Error occurred with loading all chat %s messages: %s", cnf, err)
+		chatLog(cnf.ChatID).WithError(err).Error("Failed to load chat messages")
 		return make([]tMessage, 0)
 	}
 
-	chatMessages := make([]tMessage, len(jsonMessages))
-	for n, item := range jsonMessages {
+	chatMessages := make([]tMessage, 0, len(jsonMessages))
+	for _, item := range jsonMessages {
 		var message tMessage
-		if err := json.Unmarshal([]byte(item), &message); err != nil {
-			log.Println("Error occurred with unmarshal message:", err)
+		if err := unmarshalRecord([]byte(item), &message); err != nil {
+			chatLog(cnf.ChatID).WithError(err).Error("Failed to unmarshal message")
 			continue
 		}
 		if message.ChatID != cnf.ChatID {
-			log.Printf("Warning! The message %s does not belong to the chat %s. Skip!",
-				message, cnf)
+			message.logger().Warn("Message does not belong to the chat, skip")
 			continue
 		}
 		// add chat configuration to message
 		message.chatConfig = &cnf
-		chatMessages[n] = message
+		chatMessages = append(chatMessages, message)
 	}
 	return chatMessages
 }
 
-// method deleting all chat messages
-func (cnf tChatConfig) DeleteAllChatMessages() {
-	for _, message := range cnf.GetAllChatMessage() {
-		message.Delete()
+// method registering untracked message IDs in range with estimated timestamps,
+// messages before the earliest known one get its timestamp,
+// if since is set only messages estimated not earlier than since are registered
+func (cnf tChatConfig) Backfill(fromID, toID int, anchors []tMessage, since int) int {
+	registered := 0
+	for _, message := range backfillMessages(cnf.ChatID, fromID, toID,
+		append(anchors, cnf.GetAllChatMessage()...), since) {
+
+		if message.Save() {
+			countStat(cnf.ChatID, statTracked)
+			// content type of backfilled message is not known
+			metricTracked.WithLabelValues("unknown").Inc()
+			registered++
+		}
 	}
+	return registered
 }
 
-// all chat configuration type
-type Configs map[int64]*tChatConfig
+// untracked messages in ID range with estimated timestamps, newest first
+func backfillMessages(chatID int64, fromID, toID int, anchors []tMessage, since int) []tMessage {
+	sort.Slice(anchors, func(i, j int) bool {
+		return anchors[i].MsgID < anchors[j].MsgID
+	})
 
-// chat check method
-func (c Configs) Exist(chatID int64) bool {
-	if _, ok := c[chatID]; ok {
-		return true
+	tracked := make(map[int]bool, len(anchors))
+	for _, anchor := range anchors {
+		tracked[anchor.MsgID] = true
 	}
-	return false
+
+	messages := make([]tMessage, 0)
+	// message IDs grow with time
+	for msgID := toID; msgID >= fromID; msgID-- {
+		if tracked[msgID] {
+			continue
+		}
+
+		timestamp, known := estimateTimestamp(anchors, msgID)
+		if since != 0 {
+			if !known {
+				timestamp, known = extrapolateTimestamp(anchors, msgID)
+			}
+			if !known || timestamp < since {
+				break
+			}
+		}
+
+		messages = append(messages, tMessage{
+			ChatID:     chatID,
+			MsgID:      msgID,
+			TimeStamp:  timestamp,
+			Backfilled: true,
+		})
+	}
+	return messages
+}
+
+// estimate message timestamp by the nearest known messages sorted by ID,
+// false if the message is before the earliest known one, its timestamp is returned then
+func estimateTimestamp(anchors []tMessage, msgID int) (int, bool) {
+	n := sort.Search(len(anchors), func(i int) bool {
+		return anchors[i].MsgID >= msgID
+	})
+
+	switch {
+	case len(anchors) == 0:
+		return 0, false
+	case n == 0:
+		return anchors[0].TimeStamp, anchors[0].MsgID == msgID
+	case n == len(anchors):
+		return anchors[n-1].TimeStamp, true
+	}
+
+	prev, next := anchors[n-1], anchors[n]
+	return prev.TimeStamp + (next.TimeStamp-prev.TimeStamp)*(msgID-prev.MsgID)/(next.MsgID-prev.MsgID), true
+}
+
+// estimate timestamp of message before the earliest known one by the average rate
+// of known messages sorted by ID, false if the rate is unknown
+func extrapolateTimestamp(anchors []tMessage, msgID int) (int, bool) {
+	if len(anchors) < 2 {
+		return 0, false
+	}
+
+	first, last := anchors[0], anchors[len(anchors)-1]
+	if last.MsgID <= first.MsgID || last.TimeStamp <= first.TimeStamp {
+		return 0, false
+	}
+	return first.TimeStamp - (last.TimeStamp-first.TimeStamp)*(first.MsgID-msgID)/(last.MsgID-first.MsgID), true
+}
+
+// chat statistics counters
+const (
+	statTracked    = "tracked"
+	statDeleted    = "deleted"
+	statMissing    = "missing"
+	statFailed     = "failed"
+	statTypePrefix = "type_"
+)
+
+// chat collection statistics type
+type tChatStats struct {
+	Tracked int64            `json:"tracked"`
+	Deleted int64            `json:"deleted"`
+	Missing int64            `json:"missing"`
+	Failed  int64            `json:"failed"`
+	ByType  map[string]int64 `json:"by_type"`
+	Pending int              `json:"pending"`
+	// age of the oldest pending message in seconds
+	OldestPending int `json:"oldest_pending"`
+}
+
+// increment chat statistics counter
+func countStat(chatID int64, field string) {
+	IncrementInDB(statsKey(chatID), field, 1)
+}
+
+// purge message filter type, zero fields are not applied
+type tPurgeFilter struct {
+	// only N newest messages
+	Last        int
+	SenderID    int
+	ContentType string
+	// messages older than seconds
+	OlderThan int
+	// messages with lower IDs
+	BeforeID int
+	// messages of forum topic
+	ThreadID int
+}
+
+func (f tPurgeFilter) String() string {
+	return fmt.Sprintf("last:%d sender:%d type:%s older:%ds before:%d thread:%d",
+		f.Last, f.SenderID, f.ContentType, f.OlderThan, f.BeforeID, f.ThreadID)
+}
+
+// method selecting messages matching filter
+func (f tPurgeFilter) Select(messages []tMessage, now int) []tMessage {
+	selected := make([]tMessage, 0, len(messages))
+	for _, message := range messages {
+		switch {
+		case f.SenderID != 0 && message.SenderID != f.SenderID:
+		case f.ContentType != "" && message.ContentType != f.ContentType:
+		case f.OlderThan != 0 && now-message.TimeStamp < f.OlderThan:
+		case f.BeforeID != 0 && message.MsgID >= f.BeforeID:
+		case f.ThreadID != 0 && message.ThreadID != f.ThreadID:
+		default:
+			selected = append(selected, message)
+		}
+	}
+
+	if f.Last > 0 && len(selected) > f.Last {
+		// newest messages first
+		sort.Slice(selected, func(i, j int) bool {
+			return selected[i].MsgID > selected[j].MsgID
+		})
+		selected = selected[:f.Last]
+	}
+	return selected
+}
+
+// daily active window type, minutes since midnight
+type tActiveWindow struct {
+	From int
+	To   int
+}
+
+func (w tActiveWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.From/60, w.From%60, w.To/60, w.To%60)
+}
+
+// method checking that the minute of day is inside the window
+func (w tActiveWindow) Contains(minute int) bool {
+	// window passes through midnight, e.g. 22:00-07:00
+	if w.From > w.To {
+		return minute >= w.From || minute < w.To
+	}
+	return minute >= w.From && minute < w.To
+}
+
+// parsing active window in format HH:MM-HH:MM
+func parseActiveWindow(raw string) (tActiveWindow, error) {
+	var window tActiveWindow
+
+	bounds := strings.Split(raw, "-")
+	if len(bounds) != 2 {
+		return window, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", raw)
+	}
+
+	minutes := make([]int, 2)
+	for i, bound := range bounds {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound))
+		if err != nil {
+			return window, fmt.Errorf("invalid window time %q, expected HH:MM", bound)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+
+	if minutes[0] == minutes[1] {
+		return window, fmt.Errorf("window %q is empty", raw)
+	}
+
+	window.From, window.To = minutes[0], minutes[1]
+	return window, nil
+}
+
+// all chat configuration type, safe for concurrent use,
+// stored configs are never changed, changes replace them by changed copies
+type Configs struct {
+	mu      sync.RWMutex
+	configs map[int64]*tChatConfig
+	// serializes changes of configs
+	update sync.Mutex
+}
+
+// chat check method
+func (c *Configs) Exist(chatID int64) bool {
+	return c.Get(chatID) != nil
 }
 
 // chat config exist and enable
-func (c Configs) ExistAndEnable(chatID int64) bool {
-	if config, ok := c[chatID]; ok {
+func (c *Configs) ExistAndEnable(chatID int64) bool {
+	if config := c.Get(chatID); config != nil {
 		return config.Enabled
 	}
 	return false
 }
 
+// chat config and topic exist and enable
+func (c *Configs) ExistAndEnableTopic(chatID int64, threadID int) bool {
+	if config := c.Get(chatID); config != nil {
+		return config.Enabled && config.IsTopicEnabled(threadID)
+	}
+	return false
+}
+
+// get chat config, nil if chat not exist
+func (c *Configs) Get(chatID int64) *tChatConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.configs[chatID]
+}
+
+// add or replace chat config
+func (c *Configs) Set(config *tChatConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configs[config.ChatID] = config
+}
+
+// change copy of chat config, save it and replace the config,
+// the config is not changed if change or saving fails
+func (c *Configs) Update(chatID int64, change func(config *tChatConfig) error) error {
+	c.update.Lock()
+	defer c.update.Unlock()
+
+	current := c.Get(chatID)
+	if current == nil {
+		return fmt.Errorf("chat %d is not configured", chatID)
+	}
+
+	config := current.clone()
+	if err := change(config); err != nil {
+		return err
+	}
+	if !config.Save() {
+		return errors.New("configuration not saved")
+	}
+	c.Set(config)
+	return nil
+}
+
+// remove chat config
+func (c *Configs) Remove(chatID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.configs, chatID)
+}
+
+// remove chat config and delete it from Redis, false if it is not deleted
+func (c *Configs) Delete(chatID int64) bool {
+	c.update.Lock()
+	defer c.update.Unlock()
+
+	config := c.Get(chatID)
+	if config == nil {
+		return true
+	}
+	if !config.DeleteConfig() {
+		return false
+	}
+	c.Remove(chatID)
+	return true
+}
+
+// get all chat configs
+func (c *Configs) List() []*tChatConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	configs := make([]*tChatConfig, 0, len(c.configs))
+	for _, config := range c.configs {
+		configs = append(configs, config)
+	}
+	return configs
+}
+
+// number of chat configs
+func (c *Configs) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.configs)
+}
+
 // get all messages for all chats
-func GetAllMessages(configs Configs) []tMessage {
-	jsonMessages, err := LoadFromDB("msg_*")
+func GetAllMessages(configs *Configs) []tMessage {
+	jsonMessages, err := LoadFromDB(messagesFilter())
 	if err != nil {
-		log.Println("Error occurred with loading all messages:", err)
+		log.WithError(err).Error("Failed to load all messages")
 		return make([]tMessage, 0)
 	}
-	allMessages := make([]tMessage, len(jsonMessages))
-	for n, item := range jsonMessages {
+	allMessages := make([]tMessage, 0, len(jsonMessages))
+	for _, item := range jsonMessages {
 		var message tMessage
-		if err := json.Unmarshal([]byte(item), &message); err != nil {
-			log.Println("Error occurred with unmarshal message:", err)
+		if err := unmarshalRecord([]byte(item), &message); err != nil {
+			log.WithError(err).Error("Failed to unmarshal message")
 			continue
 		}
 		// if message chat exist
-		if !configs.Exist(message.ChatID) {
-			log.Printf("Chat %d not found for message %s. Skip", message.ChatID, message)
+		config := configs.Get(message.ChatID)
+		if config == nil {
+			message.logger().Debug("Chat configuration not found for message, skip")
 			continue
 		}
 		// set chat configuration in to message object
-		message.chatConfig = configs[message.ChatID]
-		allMessages[n] = message
+		message.chatConfig = config
+		allMessages = append(allMessages, message)
 	}
 	return allMessages
 }
 
+// get saved message of chat, false if message is not tracked
+func GetMessage(chatID int64, msgID int) (tMessage, bool) {
+	var message tMessage
+	value, err := LoadValueFromDB(messageKey(chatID, msgID))
+	if err != nil || len(value) == 0 {
+		return message, false
+	}
+	if err := unmarshalRecord([]byte(value), &message); err != nil {
+		chatLog(chatID).WithFields(log.Fields{"msg_id": msgID, "error": err}).Error(
+			"Failed to unmarshal message")
+		return message, false
+	}
+	return message, true
+}
+
 // create and save new message
-func NewMessage(chatID int64, msgID, timestamp int) {
+func NewMessage(msg *tBotMessage) bool {
 	newMsg := tMessage{
-		ChatID:    chatID,
-		MsgID:     msgID,
-		TimeStamp: timestamp,
+		ChatID:      msg.Chat.ID,
+		ThreadID:    msg.ThreadID,
+		MsgID:       msg.MessageID,
+		TimeStamp:   msg.Date,
+		ContentType: messageContentType(msg.Message),
+	}
+	if msg.From != nil {
+		newMsg.SenderID = msg.From.ID
 	}
 	if !newMsg.Save() {
-		log.Printf("Message %d from chat %d don't save", msgID, chatID)
+		newMsg.logger().Error("Message not saved")
+		return false
 	}
+	countStat(newMsg.ChatID, statTracked)
+	countStat(newMsg.ChatID, statTypePrefix+newMsg.ContentType)
+	metricTracked.WithLabelValues(newMsg.ContentType).Inc()
+	return true
 }
 
 // create and save new configuration
@@ -237,29 +916,54 @@ func NewChatConfig(chatID int64, timeout int, title string) *tChatConfig {
 	}
 
 	if !newConfig.Save() {
-		log.Printf("New chat configuration fro chat %d (%s) don't save", chatID, title)
+		chatLog(chatID).Error("New chat configuration not saved")
 	}
 	return &newConfig
 }
 
 // get all chat configuration
-func GetChatConfigs() Configs {
-	chatConfigs := make(Configs)
-	values, err := LoadFromDB("chat_*")
+func GetChatConfigs() *Configs {
+	chatConfigs := &Configs{configs: make(map[int64]*tChatConfig)}
+	values, err := LoadFromDB(chatsFilter())
 	if err != nil {
-		log.Println("Error occurred with loading chat configurations", err)
+		log.WithError(err).Error("Failed to load chat configurations")
 		return chatConfigs
 	}
 
 	for _, item := range values {
 		var config tChatConfig
 
-		err := json.Unmarshal([]byte(item), &config)
+		err := unmarshalRecord([]byte(item), &config)
 		if err != nil {
-			log.Println("Error occurred with unmarshal configuration:", err)
+			log.WithError(err).Error("Failed to unmarshal chat configuration")
 			continue
 		}
-		chatConfigs[config.ChatID] = &config
+		chatConfigs.Set(&config)
 	}
 	return chatConfigs
 }
+
+// get offset of the next update after the last processed one
+func GetUpdateOffset() int {
+	value, err := LoadValueFromDB(updateOffsetKey())
+	if err != nil || len(value) == 0 {
+		return 0
+	}
+
+	lastUpdateID, err := strconv.Atoi(value)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse update offset")
+		return 0
+	}
+	return lastUpdateID + 1
+}
+
+// save ID of the last processed update
+func SaveUpdateOffset(updateID int) bool {
+	if err := SaveToDB(updateOffsetKey(), []byte(strconv.Itoa(updateID))); err != nil {
+		log.WithFields(log.Fields{"update_id": updateID, "error": err}).Error(
+			"Failed to save update offset")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEstimateTimestamp(t *testing.T) {
+	anchors := []tMessage{
+		{MsgID: 10, TimeStamp: 1000},
+		{MsgID: 20, TimeStamp: 2000},
+		{MsgID: 30, TimeStamp: 2500},
+	}
+
+	tests := []struct {
+		name      string
+		anchors   []tMessage
+		msgID     int
+		timestamp int
+		known     bool
+	}{
+		{name: "no anchors", msgID: 5, timestamp: 0, known: false},
+		{name: "before first anchor", anchors: anchors, msgID: 5, timestamp: 1000, known: false},
+		{name: "first anchor", anchors: anchors, msgID: 10, timestamp: 1000, known: true},
+		{name: "between anchors", anchors: anchors, msgID: 15, timestamp: 1500, known: true},
+		{name: "second interval", anchors: anchors, msgID: 26, timestamp: 2300, known: true},
+		{name: "last anchor", anchors: anchors, msgID: 30, timestamp: 2500, known: true},
+		{name: "after last anchor", anchors: anchors, msgID: 35, timestamp: 2500, known: true},
+	}
+
+	for _, test := range tests {
+		timestamp, known := estimateTimestamp(test.anchors, test.msgID)
+		if timestamp != test.timestamp || known != test.known {
+			t.Errorf("%s: got %d %t, expected %d %t",
+				test.name, timestamp, known, test.timestamp, test.known)
+		}
+	}
+}
+
+func TestExtrapolateTimestamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		anchors   []tMessage
+		msgID     int
+		timestamp int
+		known     bool
+	}{
+		{name: "no anchors", msgID: 5, known: false},
+		{name: "single anchor", anchors: []tMessage{{MsgID: 10, TimeStamp: 1000}}, msgID: 5, known: false},
+		{
+			name:    "same time anchors",
+			anchors: []tMessage{{MsgID: 10, TimeStamp: 1000}, {MsgID: 20, TimeStamp: 1000}},
+			msgID:   5,
+			known:   false,
+		},
+		{
+			name:      "average rate",
+			anchors:   []tMessage{{MsgID: 10, TimeStamp: 1000}, {MsgID: 20, TimeStamp: 2000}},
+			msgID:     5,
+			timestamp: 500,
+			known:     true,
+		},
+		{
+			name: "rate of first and last anchors",
+			anchors: []tMessage{
+				{MsgID: 100, TimeStamp: 10000},
+				{MsgID: 150, TimeStamp: 10100},
+				{MsgID: 200, TimeStamp: 20000},
+			},
+			msgID:     50,
+			timestamp: 5000,
+			known:     true,
+		},
+	}
+
+	for _, test := range tests {
+		timestamp, known := extrapolateTimestamp(test.anchors, test.msgID)
+		if known != test.known || known && timestamp != test.timestamp {
+			t.Errorf("%s: got %d %t, expected %d %t",
+				test.name, timestamp, known, test.timestamp, test.known)
+		}
+	}
+}
+
+func TestBackfillMessages(t *testing.T) {
+	anchors := func() []tMessage {
+		// unsorted as loaded from Redis, the command message is the newest
+		return []tMessage{
+			{MsgID: 100, TimeStamp: 10000},
+			{MsgID: 90, TimeStamp: 9000},
+			{MsgID: 95, TimeStamp: 9500},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		anchors []tMessage
+		fromID  int
+		toID    int
+		since   int
+		ids     []int
+		times   []int
+	}{
+		{
+			name:    "range skips tracked messages",
+			anchors: anchors(),
+			fromID:  93, toID: 99,
+			ids:   []int{99, 98, 97, 96, 94, 93},
+			times: []int{9900, 9800, 9700, 9600, 9400, 9300},
+		},
+		{
+			name:    "range before anchors gets the earliest anchor time",
+			anchors: anchors(),
+			fromID:  87, toID: 89,
+			ids:   []int{89, 88, 87},
+			times: []int{9000, 9000, 9000},
+		},
+		{
+			name:    "duration stops at the first message older than since",
+			anchors: anchors(),
+			fromID:  1, toID: 99,
+			since: 9650,
+			ids:   []int{99, 98, 97},
+			times: []int{9900, 9800, 9700},
+		},
+		{
+			name:    "duration extrapolates before anchors",
+			anchors: anchors(),
+			fromID:  1, toID: 99,
+			since: 8800,
+			ids:   []int{99, 98, 97, 96, 94, 93, 92, 91, 89, 88},
+			times: []int{9900, 9800, 9700, 9600, 9400, 9300, 9200, 9100, 8900, 8800},
+		},
+		{
+			name:    "duration is limited by range",
+			anchors: anchors(),
+			fromID:  97, toID: 99,
+			since: 1,
+			ids:   []int{99, 98, 97},
+			times: []int{9900, 9800, 9700},
+		},
+		{
+			name:    "duration with unknown rate registers nothing",
+			anchors: []tMessage{{MsgID: 100, TimeStamp: 10000}},
+			fromID:  1, toID: 99,
+			since: 1,
+		},
+	}
+
+	for _, test := range tests {
+		messages := backfillMessages(-100, test.fromID, test.toID, test.anchors, test.since)
+		if len(messages) != len(test.ids) {
+			t.Errorf("%s: got %d messages %v, expected %v", test.name, len(messages), messages, test.ids)
+			continue
+		}
+		for i, message := range messages {
+			if message.MsgID != test.ids[i] || message.TimeStamp != test.times[i] ||
+				message.ChatID != -100 || !message.Backfilled {
+				t.Errorf("%s: message %d is %+v, expected ID %d time %d",
+					test.name, i, message, test.ids[i], test.times[i])
+			}
+		}
+	}
+}
+
+func TestParseActiveWindow(t *testing.T) {
+	tests := []struct {
+		raw     string
+		window  tActiveWindow
+		invalid bool
+	}{
+		{raw: "09:00-18:00", window: tActiveWindow{From: 540, To: 1080}},
+		{raw: "22:00-07:00", window: tActiveWindow{From: 1320, To: 420}},
+		{raw: "00:00-23:59", window: tActiveWindow{From: 0, To: 1439}},
+		{raw: " 08:30 - 12:15 ", window: tActiveWindow{From: 510, To: 735}},
+		{raw: "10:00-10:00", invalid: true},
+		{raw: "24:00-08:00", invalid: true},
+		{raw: "9-18", invalid: true},
+		{raw: "09:00", invalid: true},
+		{raw: "09:00-12:00-18:00", invalid: true},
+	}
+
+	for _, test := range tests {
+		window, err := parseActiveWindow(test.raw)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%q: expected error, got %s", test.raw, window)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.raw, err)
+			continue
+		}
+		if window != test.window {
+			t.Errorf("%q: got %+v, expected %+v", test.raw, window, test.window)
+		}
+	}
+}
+
+func TestActiveWindowContains(t *testing.T) {
+	day := tActiveWindow{From: 540, To: 1080}
+	night := tActiveWindow{From: 1320, To: 420}
+
+	tests := []struct {
+		name     string
+		window   tActiveWindow
+		minute   int
+		contains bool
+	}{
+		{name: "day before start", window: day, minute: 539, contains: false},
+		{name: "day start", window: day, minute: 540, contains: true},
+		{name: "day middle", window: day, minute: 720, contains: true},
+		{name: "day end", window: day, minute: 1080, contains: false},
+		{name: "night before start", window: night, minute: 1319, contains: false},
+		{name: "night start", window: night, minute: 1320, contains: true},
+		{name: "night before midnight", window: night, minute: 1439, contains: true},
+		{name: "night midnight", window: night, minute: 0, contains: true},
+		{name: "night after midnight", window: night, minute: 419, contains: true},
+		{name: "night end", window: night, minute: 420, contains: false},
+		{name: "night daytime", window: night, minute: 720, contains: false},
+	}
+
+	for _, test := range tests {
+		if contains := test.window.Contains(test.minute); contains != test.contains {
+			t.Errorf("%s: got %t, expected %t", test.name, contains, test.contains)
+		}
+	}
+}
+
+func TestPurgeFilterSelect(t *testing.T) {
+	now := 10000
+	messages := []tMessage{
+		{MsgID: 1, SenderID: 7, ContentType: "text", TimeStamp: 1000},
+		{MsgID: 3, SenderID: 8, ContentType: "photo", TimeStamp: 5000},
+		{MsgID: 2, SenderID: 7, ContentType: "photo", TimeStamp: 3000},
+		{MsgID: 4, SenderID: 7, ContentType: "text", TimeStamp: 9500, ThreadID: 9},
+	}
+
+	tests := []struct {
+		name   string
+		filter tPurgeFilter
+		ids    []int
+	}{
+		{name: "no filter", filter: tPurgeFilter{}, ids: []int{1, 3, 2, 4}},
+		{name: "sender", filter: tPurgeFilter{SenderID: 7}, ids: []int{1, 2, 4}},
+		{name: "content type", filter: tPurgeFilter{ContentType: "photo"}, ids: []int{3, 2}},
+		{name: "older", filter: tPurgeFilter{OlderThan: 5000}, ids: []int{1, 3, 2}},
+		{name: "before", filter: tPurgeFilter{BeforeID: 3}, ids: []int{1, 2}},
+		{name: "last newest first", filter: tPurgeFilter{Last: 2}, ids: []int{4, 3}},
+		{name: "last over matched", filter: tPurgeFilter{Last: 5, SenderID: 8}, ids: []int{3}},
+		{name: "combined", filter: tPurgeFilter{Last: 1, SenderID: 7, OlderThan: 1000}, ids: []int{2}},
+		{name: "thread", filter: tPurgeFilter{ThreadID: 9}, ids: []int{4}},
+		{name: "last of thread", filter: tPurgeFilter{Last: 1, ThreadID: 9}, ids: []int{4}},
+		{name: "other thread", filter: tPurgeFilter{ThreadID: 8}, ids: []int{}},
+		{name: "nothing matched", filter: tPurgeFilter{ContentType: "video"}, ids: []int{}},
+	}
+
+	for _, test := range tests {
+		selected := test.filter.Select(append([]tMessage(nil), messages...), now)
+		ids := make([]int, 0, len(selected))
+		for _, message := range selected {
+			ids = append(ids, message.MsgID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.ids) {
+			t.Errorf("%s: got %v, expected %v", test.name, ids, test.ids)
+		}
+	}
+}
+
+func TestChatConfigTopic(t *testing.T) {
+	config := tChatConfig{
+		Timeout: 3600,
+		Topics: map[int]*tTopicConfig{
+			5: {Timeout: 60, Enabled: true},
+			6: {Enabled: false},
+			7: {Timeout: 120, Enabled: false},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		threadID int
+		topic    tTopicConfig
+		override bool
+	}{
+		{name: "chat", threadID: 0, topic: tTopicConfig{Timeout: 3600, Enabled: true}},
+		{name: "topic without override", threadID: 4, topic: tTopicConfig{Timeout: 3600, Enabled: true}},
+		{name: "topic timeout", threadID: 5, topic: tTopicConfig{Timeout: 60, Enabled: true}, override: true},
+		{name: "disabled topic inherits timeout", threadID: 6, topic: tTopicConfig{Timeout: 3600, Enabled: false}},
+		{name: "disabled topic timeout", threadID: 7, topic: tTopicConfig{Timeout: 120, Enabled: false}, override: true},
+	}
+
+	for _, test := range tests {
+		topic, override := config.Topic(test.threadID)
+		if topic != test.topic || override != test.override {
+			t.Errorf("%s: got %+v %t, expected %+v %t", test.name, topic, override, test.topic, test.override)
+		}
+	}
+}
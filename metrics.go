@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commands reported with own label, others are reported as unknown
+const knownCommands = " help start ping channel on off timeout topic delete purge cancel setting stats " +
+	"window timezone archive resetonedit backfill stop " +
+	"chats chat disable forget gcnow version "
+
+var (
+	metricUpdates = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gc_updates_received_total",
+		Help: "Number of updates received from Telegram.",
+	})
+	metricTracked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gc_messages_tracked_total",
+		Help: "Number of messages saved for deleting by content type.",
+	}, []string{"content_type"})
+	metricDeletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gc_deletions_total",
+		Help: "Number of message deleting attempts by result and Telegram error code.",
+	}, []string{"result", "error_code"})
+	metricCycleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gc_cycle_duration_seconds",
+		Help:    "Duration of garbage collector cycles.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+	metricPending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gc_pending_messages",
+		Help: "Number of saved messages waiting for deleting by chat.",
+	}, []string{"chat_id"})
+	metricRedisLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gc_redis_command_duration_seconds",
+		Help:    "Latency of Redis commands by command name.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+	}, []string{"command"})
+	metricCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gc_commands_total",
+		Help: "Number of bot commands received by command name.",
+	}, []string{"command"})
+)
+
+func init() {
+	prometheus.MustRegister(metricUpdates, metricTracked, metricDeletions, metricCycleDuration,
+		metricPending, metricRedisLatency, metricCommands)
+}
+
+// count received bot command
+func countCommand(command string) {
+	if !strings.Contains(knownCommands, " "+command+" ") {
+		command = "unknown"
+	}
+	metricCommands.WithLabelValues(command).Inc()
+}
+
+// set pending messages gauge of all chats
+func setPendingMetrics(pending map[int64]int) {
+	metricPending.Reset()
+	for chatID, count := range pending {
+		metricPending.WithLabelValues(strconv.FormatInt(chatID, 10)).Set(float64(count))
+	}
+}
+
+// measure latency of every Redis command
+func instrumentRedis(client redis.UniversalClient) {
+	client.WrapProcess(func(process func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			started := time.Now()
+			err := process(cmd)
+			metricRedisLatency.WithLabelValues(cmd.Name()).Observe(time.Since(started).Seconds())
+			return err
+		}
+	})
+}
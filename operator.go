@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commands available only to the bot operator in private chat
+const operatorCommands = " chats chat disable forget gcnow version "
+
+// telegram message text limit
+const maxMessageLength = 4096
+
+// checking that message is sent by the operator in private chat
+func isOperator(msg *tBotMessage) bool {
+	operatorID := currentSetting().operatorID
+	return operatorID != 0 && msg.Chat.IsPrivate() && msg.From != nil && msg.From.ID == operatorID
+}
+
+// parse chat ID argument of operator command
+func parseOperatorChat(args string) (*tChatConfig, error) {
+	chatID, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil {
+		return nil, errors.New("expected chat ID")
+	}
+	if !CONFIGS.Exist(chatID) {
+		return nil, fmt.Errorf("chat %d is not configured", chatID)
+	}
+	return CONFIGS.Get(chatID), nil
+}
+
+// operator command handler
+func operatorCommand(msg *tBotMessage, command, args string) {
+	cmdLog := chatLog(msg.Chat.ID).WithField("command", command)
+
+	switch command {
+	case "chats":
+		replyTo(msg, chatsHuman(CONFIGS.List()))
+	case "chat":
+		config, err := parseOperatorChat(args)
+		if err != nil {
+			replyTo(msg, fmt.Sprintf("Error! %s", err))
+			break
+		}
+		replyTo(msg, chatHuman(config))
+	case "disable":
+		config, err := parseOperatorChat(args)
+		if err != nil {
+			replyTo(msg, fmt.Sprintf("Error! %s", err))
+			break
+		}
+		if !config.ChangeStatus(false) {
+			replyTo(msg, "Error! Configuration not saved")
+			break
+		}
+		cmdLog.WithField("target_chat_id", config.ChatID).Info("Chat disabled by operator")
+		replyTo(msg, fmt.Sprintf("Chat %d disabled", config.ChatID))
+	case "forget":
+		config, err := parseOperatorChat(args)
+		if err != nil {
+			replyTo(msg, fmt.Sprintf("Error! %s", err))
+			break
+		}
+		forgotten := forgetChat(config)
+		cmdLog.WithFields(log.Fields{
+			"target_chat_id": config.ChatID,
+			"forgotten":      forgotten,
+		}).Info("Chat forgotten by operator")
+		replyTo(msg, fmt.Sprintf("Chat %d forgotten, %d tracked messages dropped", config.ChatID, forgotten))
+	case "gcnow":
+		if !triggerGarbageCollector() {
+			replyTo(msg, "Garbage collector cycle is already requested")
+			break
+		}
+		cmdLog.Info("Garbage collector cycle requested by operator")
+		replyTo(msg, "Garbage collector cycle requested")
+	case "version":
+		uptime := time.Since(time.Unix(0, healthStarted)).Round(time.Second)
+		replyTo(msg, fmt.Sprintf("Version: %s, Uptime: %s, Chats: %d", VERSION, uptime, CONFIGS.Len()))
+	}
+}
+
+// cancel running job and delete chat configuration and tracked messages,
+// messages are kept in the chat, returns number of dropped messages
+func forgetChat(config *tChatConfig) int {
+	JOBS.Cancel(config.ChatID)
+
+	forgotten := 0
+	for _, message := range config.GetAllChatMessage() {
+		if message.Forget() {
+			forgotten++
+		}
+	}
+	CONFIGS.Delete(config.ChatID)
+	return forgotten
+}
+
+// human readable list of configured chats
+func chatsHuman(configs []*tChatConfig) string {
+	if len(configs) == 0 {
+		return "No configured chats"
+	}
+
+	lines := make([]string, 0, len(configs))
+	for _, config := range configs {
+		lines = append(lines, fmt.Sprintf("%d %s: %s, timeout %s, pending %d",
+			config.ChatID, config.ChatTitle, statusHuman(config.Enabled),
+			time.Duration(config.Timeout)*time.Second, len(config.GetAllChatMessage())))
+	}
+	sort.Strings(lines)
+
+	// whole lines are kept within message limit
+	text := ""
+	for _, line := range lines {
+		if len(text)+len(line)+4 > maxMessageLength {
+			return text + "..."
+		}
+		text += line + "\n"
+	}
+	return strings.TrimSuffix(text, "\n")
+}
+
+// human readable chat details
+func chatHuman(config *tChatConfig) string {
+	job := "none"
+	if running := JOBS.Get(config.ChatID); running != nil {
+		job = running.String()
+	}
+
+	return fmt.Sprintf("Chat: %d %s\n"+
+		"Status: %s, Timeout: %s, Topic overrides: %d\n"+
+		"Active windows: %s, Archive: %s, Reset on edit: %s\n"+
+		"Running job: %s\n%s",
+		config.ChatID, config.ChatTitle,
+		statusHuman(config.Enabled), time.Duration(config.Timeout)*time.Second, len(config.Topics),
+		activeWindowsHuman(config), archiveHuman(config), statusHuman(config.ResetOnEdit),
+		job, statsHuman(config.Stats()))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/go-redis/redis"
+	"io/ioutil"
+	"strings"
+)
+
+// split comma separated list of Redis addresses
+func redisAddresses(list string) []string {
+	addresses := make([]string, 0)
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); len(address) > 0 {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// create TLS config of Redis connection, system CA pool is used without CA file
+func redisTLSConfig(setting *botSetting) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(setting.dbRedisTLSCA) > 0 {
+		ca, err := ioutil.ReadFile(setting.dbRedisTLSCA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificates found in Redis CA file")
+		}
+	}
+
+	if len(setting.dbRedisTLSCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(setting.dbRedisTLSCert, setting.dbRedisTLSKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// create Redis client of standalone server, Sentinel master or Cluster
+func newRedisClient(setting *botSetting) (redis.UniversalClient, error) {
+	var tlsConfig *tls.Config
+	if setting.dbRedisTLS {
+		var err error
+		if tlsConfig, err = redisTLSConfig(setting); err != nil {
+			return nil, err
+		}
+	}
+
+	password, db := setting.dbRedisPassword, setting.dbRedisDB
+	var onConnect func(conn *redis.Conn) error
+
+	// client supports only password AUTH, ACL user is authenticated on connect
+	// and DB is selected after AUTH
+	if len(setting.dbRedisUser) > 0 {
+		password, db = "", 0
+		onConnect = func(conn *redis.Conn) error {
+			auth := redis.NewStatusCmd("auth", setting.dbRedisUser, setting.dbRedisPassword)
+			if err := conn.Process(auth); err != nil {
+				return err
+			}
+			if setting.dbRedisDB > 0 {
+				return conn.Select(setting.dbRedisDB).Err()
+			}
+			return nil
+		}
+	}
+
+	switch {
+	case len(setting.dbRedisMaster) > 0:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    setting.dbRedisMaster,
+			SentinelAddrs: redisAddresses(setting.dbRedisSentinelAddress),
+			OnConnect:     onConnect,
+			Password:      password,
+			DB:            db,
+			TLSConfig:     tlsConfig,
+		}), nil
+	case setting.dbRedisCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     redisAddresses(setting.dbRedisAddress),
+			OnConnect: onConnect,
+			Password:  password,
+			TLSConfig: tlsConfig,
+		}), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:      setting.dbRedisAddress,
+			OnConnect: onConnect,
+			Password:  password,
+			DB:        db,
+			TLSConfig: tlsConfig,
+		}), nil
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strconv"
+)
+
+// current storage schema version, 0 is raw JSON records without envelope
+const schemaVersion = 2
+
+// schema version from which keys have prefix
+const prefixedKeysVersion = 2
+
+// unprefixed key of storage schema version before keys got prefix
+const legacySchemaVersionKey = "schema_version"
+
+// unprefixed key filters of JSON records and all bot keys before keys got prefix
+var (
+	legacyRecordFilters = []string{"chat_*", "msg_*"}
+	legacyKeyFilters    = []string{"chat_*", "msg_*", "stats_*", "update_offset"}
+)
+
+// exact shapes of unprefixed JSON record keys and all bot keys,
+// other keys matching filters belong to other apps
+var (
+	legacyRecordKey = regexp.MustCompile(`^(chat_-?\d+|msg_-?\d+_\d+)$`)
+	legacyKey       = regexp.MustCompile(`^(chat_-?\d+|msg_-?\d+_\d+|stats_-?\d+|update_offset)$`)
+)
+
+// stored record envelope type
+type tRecord struct {
+	Version int             `json:"v"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// marshal value to record envelope of current schema version
+func marshalRecord(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tRecord{Version: schemaVersion, Data: data})
+}
+
+// get record version and data, raw JSON record without envelope has version 0
+func parseRecord(raw []byte) (int, json.RawMessage, error) {
+	var record tRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return 0, nil, err
+	}
+	if record.Version == 0 {
+		return 0, raw, nil
+	}
+	return record.Version, record.Data, nil
+}
+
+// unmarshal record of any known schema version to value
+func unmarshalRecord(raw []byte, value interface{}) error {
+	version, data, err := parseRecord(raw)
+	if err != nil {
+		return err
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("record version %d is newer than supported %d", version, schemaVersion)
+	}
+	return json.Unmarshal(data, value)
+}
+
+// storage migration type, upgrades storage to the version
+type tMigration struct {
+	Version     int
+	Description string
+	Apply       func(version int, dryRun bool) (tMigrationReport, error)
+}
+
+// migrations in version order
+var migrations = []tMigration{
+	{
+		Version:     1,
+		Description: "wrap records to versioned envelope",
+		Apply: func(version int, dryRun bool) (tMigrationReport, error) {
+			return upgradeRecords(legacyRecordFilters, legacyRecordKey, version, dryRun,
+				func(data json.RawMessage) (json.RawMessage, error) {
+					return data, nil
+				})
+		},
+	},
+	{
+		Version:     2,
+		Description: "move keys under key prefix",
+		Apply: func(version int, dryRun bool) (tMigrationReport, error) {
+			return prefixLegacyKeys(dryRun)
+		},
+	},
+}
+
+// migration result type
+type tMigrationReport struct {
+	Records  int
+	Upgraded int
+	Failed   int
+}
+
+// get storage schema version, 0 if not set
+func GetSchemaVersion() (int, error) {
+	value, err := LoadValueFromDB(schemaVersionKey())
+	if err == nil && len(value) == 0 {
+		value, err = LoadValueFromDB(legacySchemaVersionKey)
+	}
+	if err != nil || len(value) == 0 {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// save storage schema version, the key has prefix from prefixed keys version
+func saveSchemaVersion(version int) error {
+	value := []byte(strconv.Itoa(version))
+	if version < prefixedKeysVersion {
+		return SaveToDB(legacySchemaVersionKey, value)
+	}
+	if err := SaveToDB(schemaVersionKey(), value); err != nil {
+		return err
+	}
+	return DeleteFromDB(legacySchemaVersionKey)
+}
+
+// load keys matching filters and exact key shape
+func loadShapedKeys(filters []string, shape *regexp.Regexp) ([]string, error) {
+	shaped := make([]string, 0)
+	for _, filter := range filters {
+		keys, err := LoadKeysFromDB(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if shape.MatchString(key) {
+				shaped = append(shaped, key)
+			}
+		}
+	}
+	return shaped, nil
+}
+
+// upgrade data of records with lower version, nothing is written on dry run,
+// keys which are not bot records are skipped
+func upgradeRecords(filters []string, shape *regexp.Regexp, version int, dryRun bool,
+	upgrade func(data json.RawMessage) (json.RawMessage, error)) (tMigrationReport, error) {
+
+	var report tMigrationReport
+	keys, err := loadShapedKeys(filters, shape)
+	if err != nil {
+		return report, err
+	}
+
+	for _, key := range keys {
+		keyType, err := LoadTypeFromDB(key)
+		if err != nil {
+			return report, err
+		}
+		// record deleted meanwhile
+		if keyType == "none" {
+			continue
+		}
+		report.Records++
+		if keyType != "string" {
+			log.WithFields(log.Fields{"key": key, "type": keyType}).Warn("Key is not a bot record, skip")
+			report.Failed++
+			continue
+		}
+
+		raw, err := LoadValueFromDB(key)
+		if err != nil {
+			return report, err
+		}
+
+		value, upgraded, err := upgradeRecord([]byte(raw), version, upgrade)
+		if err != nil {
+			log.WithFields(log.Fields{"key": key, "error": err}).Warn("Failed to upgrade record, skip")
+			report.Failed++
+			continue
+		}
+		if !upgraded {
+			continue
+		}
+
+		report.Upgraded++
+		if dryRun {
+			continue
+		}
+		if _, err := UpdateInDB(key, value); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// upgrade record with lower version to the version envelope,
+// returns false if the record already has the version
+func upgradeRecord(raw []byte, version int,
+	upgrade func(data json.RawMessage) (json.RawMessage, error)) ([]byte, bool, error) {
+
+	recordVersion, data, err := parseRecord(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	if recordVersion >= version {
+		return raw, false, nil
+	}
+	if data, err = upgrade(data); err != nil {
+		return nil, false, err
+	}
+	value, err := json.Marshal(tRecord{Version: version, Data: data})
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// move unprefixed bot keys under key prefix, existing prefixed keys are not overwritten
+func prefixLegacyKeys(dryRun bool) (tMigrationReport, error) {
+	var report tMigrationReport
+	keys, err := loadShapedKeys(legacyKeyFilters, legacyKey)
+	if err != nil {
+		return report, err
+	}
+
+	for _, key := range keys {
+		report.Records++
+		if dryRun {
+			report.Upgraded++
+			continue
+		}
+
+		moved, err := MoveInDB(key, dbKey(key))
+		if err != nil {
+			return report, err
+		}
+		if !moved {
+			log.WithField("key", key).Warn("Prefixed key already exists, unprefixed key is kept")
+			report.Failed++
+			continue
+		}
+		report.Upgraded++
+	}
+	return report, nil
+}
+
+// upgrade storage to current schema version, dry run only reports records to upgrade
+func migrateSchema(dryRun bool) error {
+	current, err := GetSchemaVersion()
+	if err != nil {
+		return fmt.Errorf("failed to get schema version: %s", err)
+	}
+	if current > schemaVersion {
+		return fmt.Errorf("storage schema version %d is newer than supported %d", current, schemaVersion)
+	}
+
+	// storage without schema version is new unless it has chats saved before versioning
+	if current == 0 {
+		legacyChats, err := loadShapedKeys(legacyRecordFilters[:1], legacyRecordKey)
+		if err != nil {
+			return err
+		}
+		if len(legacyChats) == 0 {
+			log.WithField("version", schemaVersion).Info("New storage schema")
+			if dryRun {
+				return nil
+			}
+			return saveSchemaVersion(schemaVersion)
+		}
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+
+		report, err := migration.Apply(migration.Version, dryRun)
+		if err != nil {
+			return fmt.Errorf("migration to version %d failed: %s", migration.Version, err)
+		}
+
+		message := "Migration applied"
+		if dryRun {
+			message = "Migration planned"
+		}
+		log.WithFields(log.Fields{
+			"version":     migration.Version,
+			"description": migration.Description,
+			"records":     report.Records,
+			"upgraded":    report.Upgraded,
+			"failed":      report.Failed,
+		}).Info(message)
+
+		if !dryRun {
+			if err := saveSchemaVersion(migration.Version); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{"from": current, "to": schemaVersion, "dry_run": dryRun}).Info(
+		"Storage schema is up to date")
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		version int
+		data    string
+		invalid bool
+	}{
+		{name: "raw record", raw: `{"MsgID":5}`, version: 0, data: `{"MsgID":5}`},
+		{name: "envelope", raw: `{"v":1,"data":{"MsgID":5}}`, version: 1, data: `{"MsgID":5}`},
+		{name: "newer envelope", raw: `{"v":3,"data":{"MsgID":5}}`, version: 3, data: `{"MsgID":5}`},
+		{name: "not JSON", raw: `chat`, invalid: true},
+		{name: "not object", raw: `42`, invalid: true},
+	}
+
+	for _, test := range tests {
+		version, data, err := parseRecord([]byte(test.raw))
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error, got version %d", test.name, version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		if version != test.version || string(data) != test.data {
+			t.Errorf("%s: got %d %s, expected %d %s", test.name, version, data, test.version, test.data)
+		}
+	}
+}
+
+func TestUnmarshalRecord(t *testing.T) {
+	current, err := marshalRecord(tMessage{ChatID: -100, MsgID: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		raw     string
+		invalid bool
+	}{
+		{name: "current version", raw: string(current)},
+		{name: "raw record", raw: `{"ChatID":-100,"MsgID":5}`},
+		{name: "first version", raw: `{"v":1,"data":{"ChatID":-100,"MsgID":5}}`},
+		{name: "newer version", raw: `{"v":99,"data":{"ChatID":-100,"MsgID":5}}`, invalid: true},
+		{name: "invalid data", raw: `{"v":1,"data":{"MsgID":"5"}}`, invalid: true},
+	}
+
+	for _, test := range tests {
+		var message tMessage
+		err := unmarshalRecord([]byte(test.raw), &message)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", test.name, message)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		if message.ChatID != -100 || message.MsgID != 5 {
+			t.Errorf("%s: got %+v", test.name, message)
+		}
+	}
+}
+
+func TestUpgradeRecord(t *testing.T) {
+	keep := func(data json.RawMessage) (json.RawMessage, error) {
+		return data, nil
+	}
+	fail := func(data json.RawMessage) (json.RawMessage, error) {
+		return nil, errors.New("unsupported data")
+	}
+
+	tests := []struct {
+		name     string
+		raw      string
+		version  int
+		upgrade  func(data json.RawMessage) (json.RawMessage, error)
+		value    string
+		upgraded bool
+		invalid  bool
+	}{
+		{
+			name: "raw record wrapped", raw: `{"ChatID":-100,"Timeout":60}`, version: 1, upgrade: keep,
+			value: `{"v":1,"data":{"ChatID":-100,"Timeout":60}}`, upgraded: true,
+		},
+		{
+			name: "lower version upgraded", raw: `{"v":1,"data":{"MsgID":5}}`, version: 2,
+			upgrade: func(data json.RawMessage) (json.RawMessage, error) {
+				return json.RawMessage(`{"MsgID":6}`), nil
+			},
+			value: `{"v":2,"data":{"MsgID":6}}`, upgraded: true,
+		},
+		{name: "same version kept", raw: `{"v":1,"data":{"MsgID":5}}`, version: 1, upgrade: fail,
+			value: `{"v":1,"data":{"MsgID":5}}`},
+		{name: "newer version kept", raw: `{"v":2,"data":{"MsgID":5}}`, version: 1, upgrade: fail,
+			value: `{"v":2,"data":{"MsgID":5}}`},
+		{name: "foreign value", raw: `some text`, version: 1, upgrade: keep, invalid: true},
+		{name: "upgrade failed", raw: `{"MsgID":5}`, version: 1, upgrade: fail, invalid: true},
+	}
+
+	for _, test := range tests {
+		value, upgraded, err := upgradeRecord([]byte(test.raw), test.version, test.upgrade)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", test.name, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		if string(value) != test.value || upgraded != test.upgraded {
+			t.Errorf("%s: got %s %t, expected %s %t", test.name, value, upgraded, test.value, test.upgraded)
+		}
+	}
+}
+
+func TestLegacyKeyShapes(t *testing.T) {
+	tests := []struct {
+		key    string
+		record bool
+		bot    bool
+	}{
+		{key: "chat_-1001234", record: true, bot: true},
+		{key: "chat_42", record: true, bot: true},
+		{key: "msg_-1001234_77", record: true, bot: true},
+		{key: "stats_-1001234", record: false, bot: true},
+		{key: "update_offset", record: false, bot: true},
+		{key: "chat_history", record: false, bot: false},
+		{key: "chat_-100_1", record: false, bot: false},
+		{key: "msg_-100", record: false, bot: false},
+		{key: "msg_-100_1_2", record: false, bot: false},
+		{key: "msg_queue", record: false, bot: false},
+		{key: "stats_daily", record: false, bot: false},
+		{key: "update_offset_old", record: false, bot: false},
+		{key: "gc:chat_-100", record: false, bot: false},
+	}
+
+	for _, test := range tests {
+		if record := legacyRecordKey.MatchString(test.key); record != test.record {
+			t.Errorf("%s: record key %t, expected %t", test.key, record, test.record)
+		}
+		if bot := legacyKey.MatchString(test.key); bot != test.bot {
+			t.Errorf("%s: bot key %t, expected %t", test.key, bot, test.bot)
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
 	"golang.org/x/net/proxy"
-	"log"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,13 +24,62 @@ Supported command:
 /off		-- the bot will be disabled
 /timeout	-- new timeout after which the messages will be deleted
 /delete		-- delete all messages
+/purge		-- delete messages selected by filters
+/cancel		-- cancel running /delete, /purge or /stop
 /setting	-- print current settings
+/stats		-- print collection statistics, "/stats reset" to reset them (admins only)
+/window		-- active windows when outdated messages may be deleted
+/timezone	-- time zone of active windows
+/topic		-- print forum topic settings, "/topic reset" to use chat settings
+/archive	-- chat receiving copies of messages before deleting
+/resetonedit	-- "on" to restart message timeout when the message is edited, "off" to disable
+/backfill	-- register messages sent before /on for deleting
 /stop		-- !!! Delete all messages, delete settings and stop the bot !!!
 
 Timeout format:
 Timeout is set in the format: <decimal><unit suffix>
 unit suffix one of "s", "m", "h"
 Example: 1h15m, 24h, 30m, 60s, 10h30m15s
+
+Forum topics:
+/on, /off and /timeout sent inside a forum topic change only this topic
+Messages of a disabled topic are never deleted
+
+Archive format:
+Archive is set in the format: <chat ID> [copy|forward], default copy
+The bot must be able to post to the archive chat
+A message is deleted only after its copy has been sent to the archive
+Example: /archive -1001234567890, /archive -1001234567890 forward, /archive off
+
+Purge format:
+/purge [last <N>] [older <duration>] [type <content type>]
+Send /purge as a reply to delete messages of the replied user
+Content types: text, photo, video, animation, sticker, voice, audio, document, service, ...
+Filters are combined, the progress message shows the result
+Example: /purge last 20, /purge older 2h type photo
+
+Backfill format:
+/backfill <from ID> <to ID> registers messages with IDs in the range
+/backfill <duration> registers messages of the last duration before the command,
+their time is estimated by the rate of known messages
+Chats with topic settings are not supported
+Timestamps of registered messages are estimated by the nearest known messages
+Example: /backfill 100 250, /backfill 24h
+
+Channels:
+Add the bot to the channel as an admin with the right to delete messages,
+then configure the channel in private chat with the bot:
+/channel <channel ID|@username> <command> [arguments]
+Supported commands: on, off, timeout, delete, purge, cancel, setting, stats, window, timezone, archive, resetonedit, backfill, stop
+Only channel admins can configure the channel
+Example: /channel @my_channel on, /channel -1001234567890 timeout 24h
+
+Active windows format:
+Windows are set in the format: HH:MM-HH:MM, separated by spaces
+Outside the windows outdated messages are kept until the next window
+Example: /window 09:00-18:00, /window 22:00-07:00 12:00-13:00, /window off
+Time zone is set by IANA name, default UTC
+Example: /timezone Europe/Moscow
 `
 
 const StartMsg = `
@@ -44,40 +99,145 @@ type botSetting struct {
 	dbRedisAddress  string
 	dbRedisDB       int
 	dbRedisPassword string
-	useSocksProxy   bool
-	socksParams     struct {
+	// ACL username, password is of the default user if not set
+	dbRedisUser string
+	// Sentinel master name and comma separated Sentinel addresses
+	dbRedisMaster          string
+	dbRedisSentinelAddress string
+	// Cluster mode, Redis address is a comma separated list of cluster nodes
+	dbRedisCluster bool
+	// TLS connection with optional CA file and client certificate
+	dbRedisTLS     bool
+	dbRedisTLSCA   string
+	dbRedisTLSCert string
+	dbRedisTLSKey  string
+	useSocksProxy  bool
+	socksParams    struct {
 		socksAddress  string
 		socksUser     string
 		socksPassword string
 	}
-	timeoutLimit int
-	// todo:
-	//useHTTPSProxy bool
-	//httpsParams struct{
-	//	httpsAddress string
-	//	httpsUser string
-	//	httpsPassword string
-	//}
+	timeoutLimit       int
+	auditLogPath       string
+	auditLogMaxSize    int64
+	auditLogMaxBackups int
+	catchUpRate        int
+	backfillLimit      int
+	httpAddress        string
+	// seconds after which the last getUpdates and GC cycle are stale
+	healthUpdatesTimeout int
+	healthGCTimeout      int
+	logLevel             string
+	logFormat            string
+	useHTTPSProxy        bool
+	httpsParams          struct {
+		httpsAddress  string
+		httpsUser     string
+		httpsPassword string
+	}
+	// telegram user ID allowed to use operator commands, 0 if disabled
+	operatorID int
+	// bearer token of HTTP admin API, API is disabled if not set
+	adminToken string
+	// prefix of Redis keys, bot username if not set
+	keyPrefix string
+}
+
+// current bot setting, replaced as a whole on reload
+var settingValue atomic.Value
+
+// get current bot setting
+func currentSetting() *botSetting {
+	return settingValue.Load().(*botSetting)
+}
+
+// replace current bot setting
+func storeSetting(setting *botSetting) {
+	settingValue.Store(setting)
+}
+
+// method getting setting names and values, secrets are redacted if required
+func (s botSetting) fields(redacted bool) [][2]string {
+	hide := func(value string) string {
+		if redacted {
+			return redact(value)
+		}
+		return value
+	}
+
+	return [][2]string{
+		{"botToken", hide(s.botToken)},
+		{"botDebug", fmt.Sprint(s.botDebug)},
+		{"gcTimeout", fmt.Sprint(int(s.gcTimeout))},
+		{"dbRedisAddress", s.dbRedisAddress},
+		{"dbRedisDB", fmt.Sprint(s.dbRedisDB)},
+		{"dbRedisPassword", hide(s.dbRedisPassword)},
+		{"dbRedisUser", hide(s.dbRedisUser)},
+		{"dbRedisMaster", s.dbRedisMaster},
+		{"dbRedisSentinelAddress", s.dbRedisSentinelAddress},
+		{"dbRedisCluster", fmt.Sprint(s.dbRedisCluster)},
+		{"dbRedisTLS", fmt.Sprint(s.dbRedisTLS)},
+		{"dbRedisTLSCA", s.dbRedisTLSCA},
+		{"dbRedisTLSCert", s.dbRedisTLSCert},
+		{"dbRedisTLSKey", s.dbRedisTLSKey},
+		{"useSocksProxy", fmt.Sprint(s.useSocksProxy)},
+		{"socksAddress", s.socksParams.socksAddress},
+		{"socksUser", hide(s.socksParams.socksUser)},
+		{"socksPassword", hide(s.socksParams.socksPassword)},
+		{"useHTTPSProxy", fmt.Sprint(s.useHTTPSProxy)},
+		{"httpsAddress", s.httpsParams.httpsAddress},
+		{"httpsUser", hide(s.httpsParams.httpsUser)},
+		{"httpsPassword", hide(s.httpsParams.httpsPassword)},
+		{"timeoutLimit", fmt.Sprint(s.timeoutLimit)},
+		{"auditLogPath", s.auditLogPath},
+		{"auditLogMaxSize", fmt.Sprint(s.auditLogMaxSize)},
+		{"auditLogMaxBackups", fmt.Sprint(s.auditLogMaxBackups)},
+		{"catchUpRate", fmt.Sprint(s.catchUpRate)},
+		{"backfillLimit", fmt.Sprint(s.backfillLimit)},
+		{"httpAddress", s.httpAddress},
+		{"healthUpdatesTimeout", fmt.Sprint(s.healthUpdatesTimeout)},
+		{"healthGCTimeout", fmt.Sprint(s.healthGCTimeout)},
+		{"logLevel", s.logLevel},
+		{"logFormat", s.logFormat},
+		{"operatorID", fmt.Sprint(s.operatorID)},
+		{"adminToken", hide(s.adminToken)},
+		{"keyPrefix", s.keyPrefix},
+	}
 }
 
 func (s botSetting) String() string {
-	return fmt.Sprint("botDebug:", s.botDebug,
-		", gcTimeout:", int(s.gcTimeout),
-		", dbRedisAddress:", s.dbRedisAddress,
-		", dbRedisDB:", s.dbRedisDB,
-		", useSocksProxy:", s.useSocksProxy,
-		", socksAddress:", s.socksParams.socksAddress,
-		", socksUser:", s.socksParams.socksUser,
-		", timeoutLimit:", s.timeoutLimit)
+	fields := make([]string, 0)
+	for _, field := range s.fields(true) {
+		fields = append(fields, field[0]+":"+field[1])
+	}
+	return strings.Join(fields, ", ")
 }
 
-// parsing and create setting
-func parseSetting(rawData map[string]string) *botSetting {
+// hide secret or personal setting value
+func redact(value string) string {
+	if len(value) == 0 {
+		return ""
+	}
+	return "***"
+}
+
+// maximum catch-up deleting rate, far above Telegram API limits
+const maxCatchUpRate = 1000
+
+// parsing and create setting, all invalid values are reported
+func parseSetting(rawSetting map[string]string) (*botSetting, []error) {
 	var setting botSetting
+	var errs []error
+	invalid := func(key, message string) {
+		errs = append(errs, fmt.Errorf("%s: %s", strings.ToUpper(key), message))
+	}
+
+	rawData, fileErrs := readSettingFiles(rawSetting)
+	errs = append(errs, fileErrs...)
 
 	// if token not set
-	if _, ok := rawData["gc_token"]; !ok {
-		log.Fatal("Bot token not set!")
+	if len(rawData["gc_token"]) == 0 {
+		errs = append(errs, errors.New("bot token not set"))
 	} else {
 		setting.botToken = rawData["gc_token"]
 	}
@@ -87,13 +247,13 @@ func parseSetting(rawData map[string]string) *botSetting {
 		case "gc_bot_debug":
 			debug, err := strconv.ParseBool(value)
 			if err != nil {
-				log.Fatal("Bot debug must be boolean")
+				invalid(key, "bot debug must be boolean")
 			}
 			setting.botDebug = debug
 		case "gc_check_timeout":
 			timeoutInt, err := strconv.Atoi(value)
-			if err != nil {
-				log.Fatal("Invalid garbage collector timeout")
+			if err != nil || timeoutInt <= 0 {
+				invalid(key, "invalid garbage collector timeout")
 			}
 			setting.gcTimeout = time.Duration(timeoutInt)
 		case "gc_redis_addr":
@@ -101,15 +261,39 @@ func parseSetting(rawData map[string]string) *botSetting {
 		case "gc_redis_db":
 			db, err := strconv.Atoi(value)
 			if err != nil {
-				log.Fatal("Invalid redis DB number")
+				invalid(key, "invalid redis DB number")
 			}
 			setting.dbRedisDB = db
 		case "gc_redis_pwd":
 			setting.dbRedisPassword = value
+		case "gc_redis_user":
+			setting.dbRedisUser = value
+		case "gc_redis_master":
+			setting.dbRedisMaster = value
+		case "gc_redis_sentinel_addr":
+			setting.dbRedisSentinelAddress = value
+		case "gc_redis_cluster":
+			cluster, err := strconv.ParseBool(value)
+			if err != nil {
+				invalid(key, "redis cluster must be boolean")
+			}
+			setting.dbRedisCluster = cluster
+		case "gc_redis_tls":
+			useTLS, err := strconv.ParseBool(value)
+			if err != nil {
+				invalid(key, "redis TLS must be boolean")
+			}
+			setting.dbRedisTLS = useTLS
+		case "gc_redis_tls_ca":
+			setting.dbRedisTLSCA = value
+		case "gc_redis_tls_cert":
+			setting.dbRedisTLSCert = value
+		case "gc_redis_tls_key":
+			setting.dbRedisTLSKey = value
 		case "gc_use_socks5":
 			useSOCKS5Bool, err := strconv.ParseBool(value)
 			if err != nil {
-				log.Fatal("Use socks5 must be boolean")
+				invalid(key, "use socks5 must be boolean")
 			}
 			setting.useSocksProxy = useSOCKS5Bool
 		case "gc_socks5_user":
@@ -118,12 +302,90 @@ func parseSetting(rawData map[string]string) *botSetting {
 			setting.socksParams.socksPassword = value
 		case "gc_socks5_addr":
 			setting.socksParams.socksAddress = value
+		case "gc_use_https_proxy":
+			useHTTPSBool, err := strconv.ParseBool(value)
+			if err != nil {
+				invalid(key, "use HTTPS proxy must be boolean")
+			}
+			setting.useHTTPSProxy = useHTTPSBool
+		case "gc_https_proxy_addr":
+			if _, err := proxyURL(value, "", ""); err != nil {
+				invalid(key, "invalid HTTPS proxy address")
+			}
+			setting.httpsParams.httpsAddress = value
+		case "gc_https_proxy_user":
+			setting.httpsParams.httpsUser = value
+		case "gc_https_proxy_pwd":
+			setting.httpsParams.httpsPassword = value
 		case "gc_timeout_limit":
 			timeout, err := strconv.Atoi(value)
-			if err != nil {
-				log.Fatal("Invalid timeout limit")
+			if err != nil || timeout <= 0 {
+				invalid(key, "invalid timeout limit")
 			}
 			setting.timeoutLimit = timeout
+		case "gc_catchup_rate":
+			rate, err := strconv.Atoi(value)
+			if err != nil || rate <= 0 || rate > maxCatchUpRate {
+				invalid(key, fmt.Sprintf("catch-up rate must be from 1 to %d", maxCatchUpRate))
+			}
+			setting.catchUpRate = rate
+		case "gc_backfill_limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				invalid(key, "invalid backfill limit")
+			}
+			setting.backfillLimit = limit
+		case "gc_http_addr":
+			setting.httpAddress = value
+		case "gc_health_updates_timeout":
+			timeout, err := strconv.Atoi(value)
+			if err != nil || timeout <= 0 {
+				invalid(key, "invalid health updates timeout")
+			}
+			setting.healthUpdatesTimeout = timeout
+		case "gc_health_gc_timeout":
+			timeout, err := strconv.Atoi(value)
+			if err != nil || timeout <= 0 {
+				invalid(key, "invalid health garbage collector timeout")
+			}
+			setting.healthGCTimeout = timeout
+		case "gc_log_level":
+			if _, err := log.ParseLevel(value); err != nil {
+				invalid(key, "invalid log level")
+			}
+			setting.logLevel = value
+		case "gc_log_format":
+			if value != "json" && value != "logfmt" && value != "text" {
+				invalid(key, "log format must be json, logfmt or text")
+			}
+			setting.logFormat = value
+		case "gc_operator_id":
+			operatorID, err := strconv.Atoi(value)
+			if err != nil || operatorID <= 0 {
+				invalid(key, "invalid operator user ID")
+			}
+			setting.operatorID = operatorID
+		case "gc_key_prefix":
+			if len(value) == 0 || strings.ContainsAny(value, keyPrefixReserved) {
+				invalid(key, "key prefix must not be empty or contain "+keyPrefixReserved)
+			}
+			setting.keyPrefix = value
+		case "gc_admin_token":
+			setting.adminToken = value
+		case "gc_audit_log":
+			setting.auditLogPath = value
+		case "gc_audit_log_max_size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				invalid(key, "invalid audit log max size")
+			}
+			setting.auditLogMaxSize = size
+		case "gc_audit_log_max_backups":
+			backups, err := strconv.Atoi(value)
+			if err != nil || backups < 0 {
+				invalid(key, "invalid audit log max backups")
+			}
+			setting.auditLogMaxBackups = backups
 		}
 	}
 
@@ -133,11 +395,52 @@ func parseSetting(rawData map[string]string) *botSetting {
 			setting.socksParams.socksPassword,
 			setting.socksParams.socksUser} {
 			if len(socksParam) == 0 {
-				log.Fatal("Not enough parameters to configure SOCKS5 proxy")
+				errs = append(errs, errors.New("not enough parameters to configure SOCKS5 proxy"))
+				break
 			}
 		}
 	}
 
+	// check https proxy settings, without address HTTPS_PROXY env is used
+	if setting.useHTTPSProxy {
+		if setting.useSocksProxy {
+			errs = append(errs, errors.New("SOCKS5 and HTTPS proxy can not be used together"))
+		}
+		if len(setting.httpsParams.httpsAddress) == 0 && len(rawData["https_proxy"]) == 0 {
+			errs = append(errs, errors.New("not enough parameters to configure HTTPS proxy"))
+		}
+		if (len(setting.httpsParams.httpsUser) == 0) != (len(setting.httpsParams.httpsPassword) == 0) {
+			errs = append(errs, errors.New("HTTPS proxy user and password must be set together"))
+		}
+	}
+
+	// check redis sentinel, cluster and TLS settings
+	if (len(setting.dbRedisMaster) == 0) != (len(redisAddresses(setting.dbRedisSentinelAddress)) == 0) {
+		errs = append(errs, errors.New("redis Sentinel master name and addresses must be set together"))
+	}
+	if setting.dbRedisCluster {
+		if len(setting.dbRedisMaster) > 0 {
+			errs = append(errs, errors.New("redis Sentinel and Cluster can not be used together"))
+		}
+		if setting.dbRedisDB != 0 {
+			errs = append(errs, errors.New("redis Cluster supports only DB 0"))
+		}
+	}
+	if (len(setting.dbRedisTLSCert) == 0) != (len(setting.dbRedisTLSKey) == 0) {
+		errs = append(errs, errors.New("redis TLS certificate and key must be set together"))
+	}
+	if setting.dbRedisTLS {
+		if _, err := redisTLSConfig(&setting); err != nil {
+			errs = append(errs, fmt.Errorf("invalid redis TLS setting: %s", err))
+		}
+	} else if len(setting.dbRedisTLSCA) > 0 || len(setting.dbRedisTLSCert) > 0 {
+		errs = append(errs, errors.New("redis TLS files require GC_REDIS_TLS"))
+	}
+
+	if len(setting.adminToken) > 0 && len(setting.httpAddress) == 0 {
+		errs = append(errs, errors.New("admin API requires HTTP listener address"))
+	}
+
 	// setup default gc timeout
 	if setting.gcTimeout == 0 {
 		setting.gcTimeout = 60
@@ -148,29 +451,141 @@ func parseSetting(rawData map[string]string) *botSetting {
 		setting.timeoutLimit = 604800
 	}
 
+	// set default health thresholds, getUpdates long polling takes up to 60 seconds
+	if setting.healthUpdatesTimeout == 0 {
+		setting.healthUpdatesTimeout = 300
+	}
+	if setting.healthGCTimeout == 0 {
+		setting.healthGCTimeout = 600
+		if gcTimeout := 3 * int(setting.gcTimeout); gcTimeout > setting.healthGCTimeout {
+			setting.healthGCTimeout = gcTimeout
+		}
+	}
+
+	// set default logger
+	if len(setting.logLevel) == 0 {
+		setting.logLevel = "info"
+	}
+	if len(setting.logFormat) == 0 {
+		setting.logFormat = "logfmt"
+	}
+
+	// set default catch-up rate
+	if setting.catchUpRate == 0 {
+		setting.catchUpRate = 10
+	}
+
+	// set default backfill limit
+	if setting.backfillLimit == 0 {
+		setting.backfillLimit = 1000
+	}
+
+	// set default audit log rotation
+	if _, ok := rawData["gc_audit_log_max_size"]; !ok {
+		setting.auditLogMaxSize = 10485760
+	}
+	if _, ok := rawData["gc_audit_log_max_backups"]; !ok {
+		setting.auditLogMaxBackups = 5
+	}
+
 	// setup default redis
 	if len(setting.dbRedisAddress) == 0 {
 		setting.dbRedisAddress = "127.0.0.1:6379"
 	}
 
-	return &setting
+	// settings are parsed in random map order, problems are reported in stable order
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+	return &setting, errs
+}
+
+// read <name>_FILE settings, file content is used as the value of <name> setting
+func readSettingFiles(rawData map[string]string) (map[string]string, []error) {
+	var errs []error
+	settingMap := make(map[string]string)
+	for key, value := range rawData {
+		settingMap[key] = value
+	}
+
+	for key, path := range rawData {
+		if !strings.HasPrefix(key, "gc_") || !strings.HasSuffix(key, "_file") {
+			continue
+		}
+
+		name := strings.TrimSuffix(key, "_file")
+		if _, ok := rawData[name]; ok {
+			errs = append(errs, fmt.Errorf("%s: can not be used together with %s",
+				strings.ToUpper(key), strings.ToUpper(name)))
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", strings.ToUpper(key), err))
+			continue
+		}
+		settingMap[name] = strings.TrimRight(string(data), "\r\n")
+	}
+	return settingMap, errs
 }
 
 // loading setting from system env
 func loadSettingFromEnv() map[string]string {
 	settingMap := make(map[string]string)
 	for _, item := range os.Environ() {
-		setting := strings.Split(item, "=")
+		setting := strings.SplitN(item, "=", 2)
+		if len(setting) != 2 {
+			continue
+		}
 		settingMap[strings.ToLower(setting[0])] = setting[1]
 	}
 	return settingMap
 }
 
-// todo
-//func loadSettingFromFile() botSetting {}
+// parse proxy address with optional basic auth, http scheme is used by default
+func proxyURL(address, user, password string) (*url.URL, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
 
-// todo:
-//func httpsProxyClient() {}
+	proxyAddress, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if proxyAddress.Scheme != "http" && proxyAddress.Scheme != "https" || len(proxyAddress.Host) == 0 {
+		return nil, fmt.Errorf("invalid proxy address %s", address)
+	}
+
+	if len(user) > 0 {
+		proxyAddress.User = url.UserPassword(user, password)
+	}
+	return proxyAddress, nil
+}
+
+// creates http.Client connection through HTTP(S) CONNECT proxy,
+// without address proxy is taken from HTTPS_PROXY env, hosts from NO_PROXY env are connected directly
+func httpsProxyClient(address, user, password string) *http.Client {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+
+	if len(address) > 0 {
+		proxyAddress, err := proxyURL(address, user, password)
+		if err != nil {
+			log.WithError(err).Error("Failed to parse proxy address")
+		} else {
+			noProxy := os.Getenv("NO_PROXY")
+			if len(noProxy) == 0 {
+				noProxy = os.Getenv("no_proxy")
+			}
+			proxyFunc := (&httpproxy.Config{HTTPSProxy: proxyAddress.String(), NoProxy: noProxy}).ProxyFunc()
+			transport.Proxy = func(r *http.Request) (*url.URL, error) {
+				return proxyFunc(r.URL)
+			}
+		}
+	}
+
+	return &http.Client{Transport: transport}
+}
 
 // creates http.Client connection through SOCKS5 proxy
 func socksProxyClient(address, user, password string) *http.Client {
@@ -183,7 +598,7 @@ func socksProxyClient(address, user, password string) *http.Client {
 	)
 
 	if err != nil {
-		fmt.Println("Error connecting to proxy:", err)
+		log.WithError(err).Error("Failed to connect to proxy")
 	}
 
 	// create client
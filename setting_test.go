@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSettingFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "GC_TEST_PLAIN", value: "value"},
+		{name: "GC_TEST_EQUALS", value: "a=b=c"},
+		{name: "GC_TEST_TRAILING_EQUALS", value: "token=="},
+		{name: "GC_TEST_EMPTY", value: ""},
+	}
+
+	for _, test := range tests {
+		os.Setenv(test.name, test.value)
+		defer os.Unsetenv(test.name)
+	}
+
+	settingMap := loadSettingFromEnv()
+	for _, test := range tests {
+		value, ok := settingMap[strings.ToLower(test.name)]
+		if !ok || value != test.value {
+			t.Errorf("%s: got %q %t, expected %q", test.name, value, ok, test.value)
+		}
+	}
+}
+
+func TestReadSettingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc_setting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		raw     map[string]string
+		key     string
+		value   string
+		invalid bool
+	}{
+		{
+			name:  "value from file",
+			raw:   map[string]string{"gc_token_file": writeFile("token", "secret")},
+			key:   "gc_token",
+			value: "secret",
+		},
+		{
+			name:  "trailing line break trimmed",
+			raw:   map[string]string{"gc_token_file": writeFile("token_lf", "secret\n")},
+			key:   "gc_token",
+			value: "secret",
+		},
+		{
+			name:  "trailing CRLF trimmed",
+			raw:   map[string]string{"gc_redis_pwd_file": writeFile("pwd_crlf", "pass word\r\n\r\n")},
+			key:   "gc_redis_pwd",
+			value: "pass word",
+		},
+		{
+			name:  "inner line breaks and spaces kept",
+			raw:   map[string]string{"gc_redis_pwd_file": writeFile("pwd_lines", " a\nb \n")},
+			key:   "gc_redis_pwd",
+			value: " a\nb ",
+		},
+		{
+			name:    "value and file conflict",
+			raw:     map[string]string{"gc_token": "plain", "gc_token_file": writeFile("conflict", "secret")},
+			key:     "gc_token",
+			value:   "plain",
+			invalid: true,
+		},
+		{
+			name:    "missing file",
+			raw:     map[string]string{"gc_token_file": filepath.Join(dir, "missing")},
+			key:     "gc_token",
+			invalid: true,
+		},
+		{
+			name:  "not bot setting",
+			raw:   map[string]string{"path_file": filepath.Join(dir, "missing")},
+			key:   "path",
+			value: "",
+		},
+	}
+
+	for _, test := range tests {
+		settingMap, errs := readSettingFiles(test.raw)
+		if test.invalid != (len(errs) > 0) {
+			t.Errorf("%s: got errors %v, expected invalid %t", test.name, errs, test.invalid)
+			continue
+		}
+		if settingMap[test.key] != test.value {
+			t.Errorf("%s: got %q, expected %q", test.name, settingMap[test.key], test.value)
+		}
+	}
+}
+
+func TestParseSettingErrors(t *testing.T) {
+	raw := map[string]string{
+		"gc_bot_debug":      "maybe",
+		"gc_check_timeout":  "0",
+		"gc_catchup_rate":   "2000000000",
+		"gc_redis_db":       "first",
+		"gc_timeout_limit":  "-1",
+		"gc_token_file":     "/nonexistent/gc_token",
+		"gc_backfill_limit": "none",
+	}
+	expected := []string{
+		"GC_BACKFILL_LIMIT: invalid backfill limit",
+		"GC_BOT_DEBUG: bot debug must be boolean",
+		"GC_CATCHUP_RATE: catch-up rate must be from 1 to 1000",
+		"GC_CHECK_TIMEOUT: invalid garbage collector timeout",
+		"GC_REDIS_DB: invalid redis DB number",
+		"GC_TIMEOUT_LIMIT: invalid timeout limit",
+		"GC_TOKEN_FILE: open /nonexistent/gc_token: no such file or directory",
+		"bot token not set",
+	}
+
+	// map order is random, every run must report the same errors in the same order
+	for run := 0; run < 20; run++ {
+		_, errs := parseSetting(raw)
+		if len(errs) != len(expected) {
+			t.Fatalf("got %d errors %v, expected %d", len(errs), errs, len(expected))
+		}
+		for i, err := range errs {
+			if err.Error() != expected[i] {
+				t.Fatalf("run %d: error %d is %q, expected %q", run, i, err, expected[i])
+			}
+		}
+	}
+}
+
+func TestParseSettingCatchUpRate(t *testing.T) {
+	tests := []struct {
+		value   string
+		rate    int
+		invalid bool
+	}{
+		{value: "1", rate: 1},
+		{value: "25", rate: 25},
+		{value: "1000", rate: 1000},
+		{value: "1001", invalid: true},
+		{value: "2000000000", invalid: true},
+		{value: "0", invalid: true},
+		{value: "-5", invalid: true},
+		{value: "fast", invalid: true},
+	}
+
+	for _, test := range tests {
+		setting, errs := parseSetting(map[string]string{"gc_token": "token", "gc_catchup_rate": test.value})
+		if test.invalid {
+			if len(errs) == 0 {
+				t.Errorf("%s: expected error, got rate %d", test.value, setting.catchUpRate)
+			}
+			continue
+		}
+		if len(errs) > 0 {
+			t.Errorf("%s: unexpected errors %v", test.value, errs)
+			continue
+		}
+		if setting.catchUpRate != test.rate {
+			t.Errorf("%s: got rate %d, expected %d", test.value, setting.catchUpRate, test.rate)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// version of exported state document
+const stateVersion = 1
+
+// import modes: merge keeps records missing in the document, replace deletes them
+const (
+	importMerge   = "merge"
+	importReplace = "replace"
+)
+
+// exported bot state type, records have the same form as in Redis
+type tState struct {
+	Version    int           `json:"version"`
+	ExportedAt string        `json:"exported_at"`
+	Chats      []tChatConfig `json:"chats"`
+	Messages   []tMessage    `json:"messages"`
+}
+
+// export all chat configurations and their messages to JSON file
+func exportState(path string) error {
+	configs := GetChatConfigs()
+	state := tState{
+		Version:    stateVersion,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Chats:      make([]tChatConfig, 0, configs.Len()),
+		Messages:   GetAllMessages(configs),
+	}
+	for _, config := range configs.List() {
+		state.Chats = append(state.Chats, *config)
+	}
+
+	sort.Slice(state.Chats, func(i, j int) bool {
+		return state.Chats[i].ChatID < state.Chats[j].ChatID
+	})
+	sort.Slice(state.Messages, func(i, j int) bool {
+		if state.Messages[i].ChatID != state.Messages[j].ChatID {
+			return state.Messages[i].ChatID < state.Messages[j].ChatID
+		}
+		return state.Messages[i].MsgID < state.Messages[j].MsgID
+	})
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"path":     path,
+		"chats":    len(state.Chats),
+		"messages": len(state.Messages),
+	}).Info("State exported")
+	return nil
+}
+
+// method checking state document, all problems are reported
+func (state tState) validate() []error {
+	var errs []error
+	if state.Version != stateVersion {
+		return []error{fmt.Errorf("unsupported state version %d, expected %d", state.Version, stateVersion)}
+	}
+
+	chats := make(map[int64]bool)
+	for _, config := range state.Chats {
+		switch {
+		case config.ChatID == 0:
+			errs = append(errs, errors.New("chat ID not set"))
+		case chats[config.ChatID]:
+			errs = append(errs, fmt.Errorf("chat %d is defined twice", config.ChatID))
+		case config.Timeout <= 0:
+			errs = append(errs, fmt.Errorf("chat %d: timeout must be greater than 0", config.ChatID))
+		}
+		chats[config.ChatID] = true
+	}
+
+	messages := make(map[string]bool)
+	for _, message := range state.Messages {
+		key := fmt.Sprintf("%d_%d", message.ChatID, message.MsgID)
+		switch {
+		case !chats[message.ChatID]:
+			errs = append(errs, fmt.Errorf("message %d: chat %d is not in the document",
+				message.MsgID, message.ChatID))
+		case message.MsgID <= 0:
+			errs = append(errs, fmt.Errorf("chat %d: message ID must be greater than 0", message.ChatID))
+		case messages[key]:
+			errs = append(errs, fmt.Errorf("chat %d: message %d is defined twice",
+				message.ChatID, message.MsgID))
+		}
+		messages[key] = true
+	}
+	return errs
+}
+
+// import chat configurations and messages from JSON file,
+// nothing is written if the document is invalid
+func importState(path, mode string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var state tState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid state document: %s", err)
+	}
+	if errs := state.validate(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		return fmt.Errorf("invalid state document, %d problems found", len(errs))
+	}
+
+	if mode == importReplace {
+		imported := make(map[int64]bool)
+		for _, config := range state.Chats {
+			imported[config.ChatID] = true
+		}
+		for _, config := range GetChatConfigs().List() {
+			if !imported[config.ChatID] && !config.DeleteConfig() {
+				return fmt.Errorf("chat %d configuration not deleted", config.ChatID)
+			}
+		}
+		if _, err := DeleteByFilterFromDB(messagesFilter()); err != nil {
+			return err
+		}
+	}
+
+	for _, config := range state.Chats {
+		if !config.Save() {
+			return fmt.Errorf("chat %d configuration not saved", config.ChatID)
+		}
+	}
+	for _, message := range state.Messages {
+		if !message.Save() {
+			return fmt.Errorf("chat %d: message %d not saved", message.ChatID, message.MsgID)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"path":     path,
+		"mode":     mode,
+		"chats":    len(state.Chats),
+		"messages": len(state.Messages),
+	}).Info("State imported")
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// chan for stopping the update receiver
+var stopUpdates = make(chan struct{})
+
+// bot message type with fields unknown to tgbotapi
+type tBotMessage struct {
+	*tgbotapi.Message
+	// forum topic of message, 0 for the main chat or the General topic
+	ThreadID int
+}
+
+func (msg *tBotMessage) UnmarshalJSON(data []byte) error {
+	var message tgbotapi.Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		return err
+	}
+
+	var topic struct {
+		MessageThreadID int  `json:"message_thread_id"`
+		IsTopicMessage  bool `json:"is_topic_message"`
+	}
+	if err := json.Unmarshal(data, &topic); err != nil {
+		return err
+	}
+
+	msg.Message = &message
+	// replies in ordinary supergroups have thread ID too
+	if topic.IsTopicMessage {
+		msg.ThreadID = topic.MessageThreadID
+	}
+	return nil
+}
+
+// bot update type
+type tBotUpdate struct {
+	UpdateID          int          `json:"update_id"`
+	Message           *tBotMessage `json:"message"`
+	EditedMessage     *tBotMessage `json:"edited_message"`
+	ChannelPost       *tBotMessage `json:"channel_post"`
+	EditedChannelPost *tBotMessage `json:"edited_channel_post"`
+}
+
+// get new updates starting from offset
+func getUpdates(offset, timeout int) ([]tBotUpdate, error) {
+	params := url.Values{}
+	if offset != 0 {
+		params.Add("offset", strconv.Itoa(offset))
+	}
+	params.Add("timeout", strconv.Itoa(timeout))
+
+	resp, err := BOT.MakeRequest("getUpdates", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []tBotUpdate
+	if err := json.Unmarshal(resp.Result, &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// send text message to chat topic, optionally as reply
+func sendMessage(chatID int64, threadID, replyToID int, text string) (*tBotMessage, error) {
+	params := url.Values{}
+	params.Add("chat_id", strconv.FormatInt(chatID, 10))
+	params.Add("text", text)
+	if threadID != 0 {
+		params.Add("message_thread_id", strconv.Itoa(threadID))
+	}
+	if replyToID != 0 {
+		params.Add("reply_to_message_id", strconv.Itoa(replyToID))
+	}
+
+	resp, err := BOT.MakeRequest("sendMessage", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var message tBotMessage
+	if err := json.Unmarshal(resp.Result, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// edit text of message sent by bot
+func editMessage(msg *tBotMessage, text string) error {
+	_, err := BOT.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, text))
+	return err
+}
+
+// copy or forward message to another chat
+func copyMessage(toChatID, fromChatID int64, msgID int, forward bool) (tgbotapi.APIResponse, error) {
+	params := url.Values{}
+	params.Add("chat_id", strconv.FormatInt(toChatID, 10))
+	params.Add("from_chat_id", strconv.FormatInt(fromChatID, 10))
+	params.Add("message_id", strconv.Itoa(msgID))
+	params.Add("disable_notification", "true")
+
+	method := "copyMessage"
+	if forward {
+		method = "forwardMessage"
+	}
+	return BOT.MakeRequest(method, params)
+}
+
+// checking that API error means the source message no longer exists
+func isMessageNotFound(resp tgbotapi.APIResponse) bool {
+	return resp.ErrorCode == 400 && strings.Contains(resp.Description, "not found") &&
+		strings.Contains(resp.Description, "message to")
+}
+
+// checking that API error means the message can never be copied or forwarded,
+// e.g. service message or message with protected content
+func isMessageNotCopyable(resp tgbotapi.APIResponse) bool {
+	return resp.ErrorCode == 400 && (strings.Contains(resp.Description, "can't be copied") ||
+		strings.Contains(resp.Description, "can't be forwarded"))
+}
+
+// checking that user is creator or administrator of chat
+func isChatAdmin(chatID int64, userID int) bool {
+	member, err := BOT.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID})
+	if err != nil {
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+// content type of message
+func messageContentType(msg *tgbotapi.Message) string {
+	switch {
+	case msg.Text != "":
+		return "text"
+	case msg.Animation != nil:
+		return "animation"
+	case msg.Photo != nil:
+		return "photo"
+	case msg.Video != nil:
+		return "video"
+	case msg.VideoNote != nil:
+		return "video_note"
+	case msg.Voice != nil:
+		return "voice"
+	case msg.Audio != nil:
+		return "audio"
+	case msg.Document != nil:
+		return "document"
+	case msg.Sticker != nil:
+		return "sticker"
+	case msg.Contact != nil:
+		return "contact"
+	case msg.Venue != nil:
+		return "venue"
+	case msg.Location != nil:
+		return "location"
+	case msg.Game != nil:
+		return "game"
+	case msg.Invoice != nil:
+		return "invoice"
+	}
+	return "service"
+}
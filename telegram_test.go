@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"testing"
+)
+
+func TestDeletingErrors(t *testing.T) {
+	tests := []struct {
+		code        int
+		description string
+		notFound    bool
+		notCopyable bool
+	}{
+		{code: 400, description: "Bad Request: message to delete not found", notFound: true},
+		{code: 400, description: "Bad Request: message to copy not found", notFound: true},
+		{code: 400, description: "Bad Request: message can't be copied", notCopyable: true},
+		{code: 400, description: "Bad Request: message can't be forwarded", notCopyable: true},
+		{code: 400, description: "Bad Request: message has protected content and can't be forwarded", notCopyable: true},
+		{code: 400, description: "Bad Request: message can't be deleted"},
+		{code: 403, description: "Forbidden: bot is not a member of the channel chat"},
+		{code: 429, description: "Too Many Requests: retry after 5"},
+	}
+
+	for _, test := range tests {
+		resp := tgbotapi.APIResponse{ErrorCode: test.code, Description: test.description}
+		if notFound := isMessageNotFound(resp); notFound != test.notFound {
+			t.Errorf("%q: not found %t, expected %t", test.description, notFound, test.notFound)
+		}
+		if notCopyable := isMessageNotCopyable(resp); notCopyable != test.notCopyable {
+			t.Errorf("%q: not copyable %t, expected %t", test.description, notCopyable, test.notCopyable)
+		}
+	}
+}